@@ -1,15 +1,68 @@
 package tracker
 
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
 var DefaultURL = "https://www.pivotaltracker.com"
 
 type Client struct {
 	conn connection
 }
 
+// ClientOption configures a Client built by NewClientWithURL.
+type ClientOption func(*Client)
+
+// Token authenticates every request with the given API token.
+func Token(token string) ClientOption {
+	return func(c *Client) {
+		c.conn.token = token
+	}
+}
+
+// Retry retries idempotent requests according to policy.
+func Retry(policy RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.conn.retry = policy
+	}
+}
+
+// Context cancels every request, and any wait between retries, when ctx is
+// done.
+func Context(ctx context.Context) ClientOption {
+	return func(c *Client) {
+		c.conn.ctx = ctx
+	}
+}
+
 func NewClient(token string) *Client {
-	return &Client{
-		conn: newConnection(token),
+	return NewClientWithHTTPClient(token, &http.Client{})
+}
+
+func NewClientWithHTTPClient(token string, httpClient *http.Client) *Client {
+	return NewClientWithURL(DefaultURL, httpClient, Token(token))
+}
+
+// NewClientWithURL builds a client for the Tracker at baseURL, e.g.
+// https://www.pivotaltracker.com, that makes requests with httpClient.
+func NewClientWithURL(baseURL string, httpClient *http.Client, options ...ClientOption) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	client := &Client{
+		conn: newConnection(baseURL, "", httpClient),
 	}
+	for _, option := range options {
+		option(client)
+	}
+	return client
+}
+
+// BaseURL is the Tracker the client makes requests to.
+func (c Client) BaseURL() string {
+	return c.conn.baseURL
 }
 
 func (c Client) Me() (me Me, err error) {
@@ -23,6 +76,17 @@ func (c Client) Me() (me Me, err error) {
 	return me, err
 }
 
+func (c Client) Story(storyId int) (story Story, err error) {
+	request, err := c.conn.CreateRequest("GET", fmt.Sprintf("/stories/%d", storyId))
+	if err != nil {
+		return story, err
+	}
+
+	err = c.conn.Do(request, &story)
+
+	return story, err
+}
+
 func (c Client) InProject(projectId int) ProjectClient {
 	return ProjectClient{
 		id:   projectId,
@@ -1,7 +1,9 @@
 package tracker_test
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -70,6 +72,73 @@ var _ = Describe("Tracker Client", func() {
 			Ω(err).To(MatchError("invalid token"))
 		})
 
+		It("decodes the error Tracker explains the failure with", func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.RespondWith(http.StatusNotFound, Fixture("error.json")),
+			))
+
+			_, err := tracker.NewClient("api-token").InProject(123).Stories(tracker.StoriesQuery{})
+			Ω(err).To(MatchError("request failed (404): unfound_resource: The object you tried to access could not be found. It may have been removed by another user, you may be using the ID of another object type, or you may be trying to access a sub-resource at the wrong point in a tree. Possible fix: Check the ID of the project and try again."))
+
+			apiError, ok := err.(*tracker.APIError)
+			Ω(ok).To(BeTrue())
+			Ω(apiError.StatusCode).To(Equal(http.StatusNotFound))
+			Ω(apiError.Code).To(Equal("unfound_resource"))
+			Ω(apiError.Kind).To(Equal("error"))
+			Ω(apiError.Message).To(Equal("The object you tried to access could not be found."))
+			Ω(apiError.PossibleFix).To(Equal("Check the ID of the project and try again."))
+		})
+
+		It("keeps the status code if the error can't be decoded", func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.RespondWith(http.StatusBadGateway, "<html>Bad Gateway</html>"),
+			))
+
+			_, err := client.Me()
+			Ω(err).To(MatchError("request failed (502)"))
+			Ω(err.(*tracker.APIError).StatusCode).To(Equal(http.StatusBadGateway))
+		})
+
+		It("uses the given HTTP client", func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/services/v5/me"),
+				verifyTrackerToken(),
+
+				ghttp.RespondWith(http.StatusOK, Fixture("me.json")),
+			))
+
+			roundTripper := &countingRoundTripper{}
+			client := tracker.NewClientWithHTTPClient("api-token", &http.Client{Transport: roundTripper})
+			_, err := client.Me()
+
+			Ω(err).ToNot(HaveOccurred())
+			Ω(roundTripper.requests).To(Equal(1))
+		})
+
+		It("uses the given base URL and options", func() {
+			otherServer := ghttp.NewServer()
+			defer otherServer.Close()
+			otherServer.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/services/v5/me"),
+					ghttp.VerifyHeaderKV("X-TrackerToken", "other-token"),
+					ghttp.RespondWith(http.StatusOK, Fixture("me.json")),
+				),
+			)
+
+			client := tracker.NewClientWithURL(otherServer.URL(), nil,
+				tracker.Token("other-token"),
+				tracker.Retry(tracker.RetryPolicy{MaxRetries: 1}),
+			)
+			me, err := client.Me()
+
+			Ω(err).ToNot(HaveOccurred())
+			Ω(me.Username).To(Equal("vader"))
+			Ω(client.BaseURL()).To(Equal(otherServer.URL()))
+			Ω(server.ReceivedRequests()).To(BeEmpty())
+		})
+
 		It("returns an error if the request fails", func() {
 			server.Close()
 
@@ -81,6 +150,18 @@ var _ = Describe("Tracker Client", func() {
 			server = ghttp.NewServer()
 		})
 
+		It("returns an error if the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			client := tracker.NewClientWithURL(server.URL(), nil, tracker.Token("api-token"), tracker.Context(ctx))
+			_, err := client.Me()
+
+			Ω(err).To(HaveOccurred())
+			Ω(err.Error()).To(MatchRegexp("failed to make request: .*context canceled"))
+			Ω(server.ReceivedRequests()).To(BeEmpty())
+		})
+
 		It("returns an error if the request can't be created", func() {
 			tracker.DefaultURL = "aaaaa)#Q&%*(*"
 
@@ -104,6 +185,139 @@ var _ = Describe("Tracker Client", func() {
 		})
 	})
 
+	Describe("getting a story", func() {
+		It("gets the story without needing its project", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/services/v5/stories/560"),
+					verifyTrackerToken(),
+
+					ghttp.RespondWith(http.StatusOK, Fixture("story.json")),
+				),
+			)
+
+			client := tracker.NewClient("api-token")
+
+			story, err := client.Story(560)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(story.ID).To(Equal(560))
+			Ω(story.State).To(Equal(tracker.StoryState(tracker.StoryStateFinished)))
+		})
+	})
+
+	Describe("retrying requests", func() {
+		var policy tracker.RetryPolicy
+
+		retryingClient := func(options ...tracker.ClientOption) *tracker.Client {
+			options = append([]tracker.ClientOption{tracker.Token("api-token"), tracker.Retry(policy)}, options...)
+			return tracker.NewClientWithURL(tracker.DefaultURL, nil, options...)
+		}
+
+		BeforeEach(func() {
+			policy = tracker.RetryPolicy{
+				MaxRetries: 2,
+				MinBackoff: time.Millisecond,
+				MaxBackoff: 10 * time.Millisecond,
+			}
+		})
+
+		It("retries server errors until the request succeeds", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+				ghttp.RespondWith(http.StatusBadGateway, ""),
+				ghttp.RespondWith(http.StatusOK, Fixture("me.json")),
+			)
+
+			me, err := retryingClient().Me()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(me.Username).To(Equal("vader"))
+		})
+
+		It("gives up after the maximum number of retries", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+				ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+				ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+			)
+
+			_, err := retryingClient().Me()
+			Ω(err).To(MatchError("request failed (503)"))
+			Ω(server.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("waits as long as a rate limited response asks", func() {
+			policy.MaxBackoff = 2 * time.Second
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusTooManyRequests, "", http.Header{"Retry-After": {"1"}}),
+				ghttp.RespondWith(http.StatusOK, Fixture("me.json")),
+			)
+
+			start := time.Now()
+			_, err := retryingClient().Me()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(time.Since(start)).To(BeNumerically(">=", time.Second))
+		})
+
+		It("gives up rather than wait longer than the maximum backoff", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusTooManyRequests, "", http.Header{"Retry-After": {"3600"}}),
+			)
+
+			start := time.Now()
+			_, err := retryingClient().Me()
+			Ω(err).To(MatchError("request failed (429)"))
+			Ω(time.Since(start)).To(BeNumerically("<", time.Second))
+			Ω(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("gives up rather than wait past the deadline", func() {
+			policy.MaxBackoff = time.Hour
+			policy.Deadline = time.Now().Add(100 * time.Millisecond)
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusTooManyRequests, "", http.Header{"Retry-After": {"60"}}),
+			)
+
+			_, err := retryingClient().Me()
+			Ω(err).To(MatchError("request failed (429)"))
+			Ω(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("stops waiting to retry when the context is cancelled", func() {
+			policy.MaxBackoff = time.Hour
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusTooManyRequests, "", http.Header{"Retry-After": {"60"}}),
+			)
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+
+			start := time.Now()
+			_, err := retryingClient(tracker.Context(ctx)).Me()
+			Ω(err).To(MatchError("failed to make request: context canceled"))
+			Ω(time.Since(start)).To(BeNumerically("<", 10*time.Second))
+			Ω(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("does not retry requests that are not idempotent", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+			)
+
+			err := retryingClient().InProject(99).CreateStory(tracker.Story{})
+			Ω(err).To(MatchError("request failed (503)"))
+			Ω(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("does not retry client errors", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusNotFound, ""),
+			)
+
+			_, err := retryingClient().Me()
+			Ω(err).To(MatchError("request failed (404)"))
+			Ω(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
 	Describe("listing stories", func() {
 		It("gets all the stories by default", func() {
 			server.AppendHandlers(
@@ -120,6 +334,8 @@ var _ = Describe("Tracker Client", func() {
 			stories, err := client.InProject(99).Stories(tracker.StoriesQuery{})
 			Ω(stories).Should(HaveLen(4))
 			Ω(err).ToNot(HaveOccurred())
+			Ω(stories[1].Labels).Should(ContainElement(tracker.Label{ID: 2010, ProjectID: 99, Name: "mnt"}))
+			Ω(stories[1].RequestedByID).Should(Equal(104))
 		})
 
 		It("allows different queries to be made", func() {
@@ -143,6 +359,102 @@ var _ = Describe("Tracker Client", func() {
 		})
 	})
 
+	Describe("listing iterations", func() {
+		It("gets the iterations and their stories", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/services/v5/projects/99/iterations", "date_format=millis&scope=current"),
+					verifyTrackerToken(),
+
+					ghttp.RespondWith(http.StatusOK, Fixture("iterations.json")),
+				),
+			)
+
+			client := tracker.NewClient("api-token")
+
+			iterations, err := client.InProject(99).Iterations(tracker.IterationsQuery{Scope: tracker.IterationScopeCurrent})
+			Ω(err).ToNot(HaveOccurred())
+			Ω(iterations).Should(HaveLen(1))
+			Ω(iterations[0].Number).Should(Equal(4))
+			Ω(iterations[0].Start).Should(Equal(int64(1401062400000)))
+			Ω(iterations[0].Stories).Should(HaveLen(1))
+			Ω(iterations[0].Stories[0].ID).Should(Equal(560))
+		})
+	})
+
+	Describe("listing epics", func() {
+		It("gets the epics and their labels", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/services/v5/projects/99/epics"),
+					verifyTrackerToken(),
+
+					ghttp.RespondWith(http.StatusOK, Fixture("epics.json")),
+				),
+			)
+
+			client := tracker.NewClient("api-token")
+
+			epics, err := client.InProject(99).Epics()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(epics).Should(Equal([]tracker.Epic{{
+				ID:        5,
+				ProjectID: 99,
+				Name:      "Death Star",
+				Label:     tracker.Label{ID: 2011, ProjectID: 99, Name: "deathstar"},
+			}}))
+		})
+	})
+
+	Describe("searching a project", func() {
+		It("gets the matching stories", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/services/v5/projects/99/search", "date_format=millis&query=state%3Afinished+-label%3Awip"),
+					verifyTrackerToken(),
+
+					ghttp.RespondWith(http.StatusOK, Fixture("search.json")),
+				),
+			)
+
+			client := tracker.NewClient("api-token")
+
+			result, err := client.InProject(99).Search("state:finished -label:wip")
+			Ω(err).ToNot(HaveOccurred())
+			Ω(result.Query).Should(Equal("state:finished -label:wip"))
+			Ω(result.Stories.TotalHits).Should(Equal(1))
+			Ω(result.Stories.Stories).Should(HaveLen(1))
+			Ω(result.Stories.Stories[0].ID).Should(Equal(560))
+		})
+	})
+
+	Describe("listing project memberships", func() {
+		It("gets the people in the project", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/services/v5/projects/99/memberships"),
+					verifyTrackerToken(),
+
+					ghttp.RespondWith(http.StatusOK, Fixture("memberships.json")),
+				),
+			)
+
+			client := tracker.NewClient("api-token")
+
+			memberships, err := client.InProject(99).ProjectMemberships()
+			Ω(err).ToNot(HaveOccurred())
+			Ω(memberships).Should(HaveLen(2))
+			Ω(memberships[0].Person).Should(Equal(tracker.Person{
+				ID:       101,
+				Name:     "Darth Vader",
+				Initials: "DV",
+				Username: "vader",
+				Email:    "vader@deathstar.mil",
+			}))
+			Ω(memberships[1].Role).Should(Equal("member"))
+		})
+	})
+
 	Describe("listing a story's activity", func() {
 		It("gets the story's activity", func() {
 			server.AppendHandlers(
@@ -238,3 +550,12 @@ func verifyTrackerToken() http.HandlerFunc {
 
 	return ghttp.VerifyHeader(headers)
 }
+
+type countingRoundTripper struct {
+	requests int
+}
+
+func (t *countingRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(request)
+}
@@ -1,21 +1,27 @@
 package tracker
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 type connection struct {
-	token  string
-	client *http.Client
+	ctx     context.Context
+	baseURL string
+	token   string
+	client  *http.Client
+	retry   RetryPolicy
 }
 
-func newConnection(token string) connection {
+func newConnection(baseURL string, token string, client *http.Client) connection {
 	return connection{
-		token:  token,
-		client: &http.Client{},
+		ctx:     context.Background(),
+		baseURL: baseURL,
+		token:   token,
+		client:  client,
 	}
 }
 
@@ -33,7 +39,7 @@ func (c connection) Do(request *http.Request, response interface{}) error {
 }
 
 func (c connection) CreateRequest(method string, path string) (*http.Request, error) {
-	request, err := http.NewRequest(method, DefaultURL+"/services/v5"+path, nil)
+	request, err := http.NewRequest(method, c.baseURL+"/services/v5"+path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %s", err)
 	}
@@ -43,17 +49,29 @@ func (c connection) CreateRequest(method string, path string) (*http.Request, er
 }
 
 func (c connection) sendRequest(request *http.Request) (*http.Response, error) {
+	request = request.WithContext(c.ctx)
 	response, err := c.client.Do(request)
+	for retry := 1; retry <= c.retry.MaxRetries && c.retry.retryable(request, response, err); retry++ {
+		wait := c.retry.backoff(retry, response)
+		if !c.retry.allows(wait) {
+			break
+		}
+		if err == nil {
+			response.Body.Close()
+		}
+		select {
+		case <-time.After(wait):
+		case <-c.ctx.Done():
+			return nil, fmt.Errorf("failed to make request: %s", c.ctx.Err())
+		}
+		response, err = c.client.Do(request)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %s", err)
 	}
 
-	if response.StatusCode == http.StatusUnauthorized {
-		return nil, errors.New("invalid token")
-	}
-
 	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request failed (%d)", response.StatusCode)
+		return nil, newAPIError(response)
 	}
 
 	return response, nil
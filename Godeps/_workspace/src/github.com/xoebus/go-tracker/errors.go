@@ -0,0 +1,54 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// APIError is an unsuccessful response from Tracker, along with the
+// explanation Tracker gave for it, if any.
+type APIError struct {
+	StatusCode int `json:"-"`
+
+	Code           string `json:"code"`
+	Kind           string `json:"kind"`
+	Message        string `json:"error"`
+	GeneralProblem string `json:"general_problem"`
+	PossibleFix    string `json:"possible_fix"`
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("request failed (%d)", e.StatusCode)
+	if e.StatusCode == http.StatusUnauthorized {
+		message = "invalid token"
+	}
+	if e.Code != "" {
+		message += ": " + e.Code
+	}
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if e.GeneralProblem != "" {
+		message += " " + e.GeneralProblem
+	}
+	if e.PossibleFix != "" {
+		message += " Possible fix: " + e.PossibleFix
+	}
+	return message
+}
+
+// newAPIError reads Tracker's explanation of a failed response. A body that
+// isn't one leaves only the status code to go on.
+func newAPIError(response *http.Response) *APIError {
+	defer response.Body.Close()
+
+	apiError := &APIError{}
+	body, err := ioutil.ReadAll(response.Body)
+	if err == nil && json.Unmarshal(body, apiError) != nil {
+		apiError = &APIError{}
+	}
+	apiError.StatusCode = response.StatusCode
+	return apiError
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -26,6 +27,50 @@ func (p ProjectClient) Stories(query StoriesQuery) (stories []Story, err error)
 	return stories, err
 }
 
+func (p ProjectClient) Iterations(query IterationsQuery) (iterations []Iteration, err error) {
+	params := query.Query().Encode()
+	request, err := p.createRequest("GET", "/iterations?"+params)
+	if err != nil {
+		return iterations, err
+	}
+
+	err = p.conn.Do(request, &iterations)
+	return iterations, err
+}
+
+func (p ProjectClient) Epics() (epics []Epic, err error) {
+	request, err := p.createRequest("GET", "/epics")
+	if err != nil {
+		return epics, err
+	}
+
+	err = p.conn.Do(request, &epics)
+	return epics, err
+}
+
+func (p ProjectClient) Search(query string) (result SearchResult, err error) {
+	params := url.Values{}
+	params.Set("date_format", "millis")
+	params.Set("query", query)
+	request, err := p.createRequest("GET", "/search?"+params.Encode())
+	if err != nil {
+		return result, err
+	}
+
+	err = p.conn.Do(request, &result)
+	return result, err
+}
+
+func (p ProjectClient) ProjectMemberships() (memberships []ProjectMembership, err error) {
+	request, err := p.createRequest("GET", "/memberships")
+	if err != nil {
+		return memberships, err
+	}
+
+	err = p.conn.Do(request, &memberships)
+	return memberships, err
+}
+
 func (p ProjectClient) StoryActivity(storyId int, query ActivityQuery) (activities []Activity, err error) {
 	url := fmt.Sprintf("/stories/%d/activity", storyId)
 	params := query.Query().Encode()
@@ -35,6 +35,32 @@ func (query StoriesQuery) Query() url.Values {
 	return params
 }
 
+type IterationsQuery struct {
+	Scope IterationScope
+
+	Limit  int
+	Offset int
+}
+
+func (query IterationsQuery) Query() url.Values {
+	params := url.Values{}
+	params.Set("date_format", "millis")
+
+	if query.Scope != "" {
+		params.Set("scope", string(query.Scope))
+	}
+
+	if query.Limit != 0 {
+		params.Set("limit", fmt.Sprintf("%d", query.Limit))
+	}
+
+	if query.Offset != 0 {
+		params.Set("offset", fmt.Sprintf("%d", query.Offset))
+	}
+
+	return params
+}
+
 type ActivityQuery struct {
 	Limit          int
 	Offset         int
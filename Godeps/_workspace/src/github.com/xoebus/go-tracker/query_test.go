@@ -38,4 +38,26 @@ var _ = Describe("Queries", func() {
 			Ω(queryString(query)).Should(Equal("date_format=millis&limit=33"))
 		})
 	})
+
+	Describe("IterationsQuery", func() {
+		It("only has date_format by default", func() {
+			query := tracker.IterationsQuery{}
+			Ω(queryString(query)).Should(Equal("date_format=millis"))
+		})
+
+		It("can query by scope", func() {
+			query := tracker.IterationsQuery{
+				Scope: tracker.IterationScopeCurrent,
+			}
+			Ω(queryString(query)).Should(Equal("date_format=millis&scope=current"))
+		})
+
+		It("can page through the results", func() {
+			query := tracker.IterationsQuery{
+				Limit:  5,
+				Offset: 10,
+			}
+			Ω(queryString(query)).Should(Equal("date_format=millis&limit=5&offset=10"))
+		})
+	})
 })
@@ -20,6 +20,65 @@ type Story struct {
 	Description string     `json:"description,omitempty"`
 	Type        StoryType  `json:"story_type,omitempty"`
 	State       StoryState `json:"current_state,omitempty"`
+
+	Labels []Label `json:"labels,omitempty"`
+
+	OwnerIDs      []int `json:"owner_ids,omitempty"`
+	RequestedByID int   `json:"requested_by_id,omitempty"`
+}
+
+type Label struct {
+	ID        int    `json:"id,omitempty"`
+	ProjectID int    `json:"project_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+type Iteration struct {
+	Number    int     `json:"number"`
+	ProjectID int     `json:"project_id"`
+	Start     int64   `json:"start"`
+	Finish    int64   `json:"finish"`
+	Stories   []Story `json:"stories"`
+}
+
+type IterationScope string
+
+const (
+	IterationScopeDone           = "done"
+	IterationScopeCurrent        = "current"
+	IterationScopeBacklog        = "backlog"
+	IterationScopeCurrentBacklog = "current_backlog"
+)
+
+type Epic struct {
+	ID        int    `json:"id"`
+	ProjectID int    `json:"project_id"`
+	Name      string `json:"name"`
+	Label     Label  `json:"label"`
+}
+
+type SearchResult struct {
+	Query   string              `json:"query"`
+	Stories StoriesSearchResult `json:"stories"`
+}
+
+type StoriesSearchResult struct {
+	Stories   []Story `json:"stories"`
+	TotalHits int     `json:"total_hits"`
+}
+
+type Person struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Initials string `json:"initials"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type ProjectMembership struct {
+	ID     int    `json:"id"`
+	Role   string `json:"role"`
+	Person Person `json:"person"`
 }
 
 type StoryType string
@@ -0,0 +1,81 @@
+package tracker
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how idempotent requests are retried when Tracker is
+// unreachable, overloaded or rate limiting. The zero value never retries.
+type RetryPolicy struct {
+	// MaxRetries is how many times a request is retried after the first
+	// attempt fails.
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between
+	// attempts. A Retry-After header takes precedence over them, but a request
+	// asking for a longer wait than MaxBackoff is given up on instead.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Deadline, if set, is when to stop retrying and give up.
+	Deadline time.Time
+}
+
+func (p RetryPolicy) retryable(request *http.Request, response *http.Response, err error) bool {
+	if request.Method != "GET" && request.Method != "HEAD" {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff is how long to wait before the given retry, with jitter so that
+// many clients don't retry in lockstep.
+func (p RetryPolicy) backoff(retry int, response *http.Response) time.Duration {
+	if response != nil {
+		if wait, ok := retryAfter(response.Header.Get("Retry-After")); ok {
+			return wait
+		}
+	}
+
+	backoff := p.MinBackoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func (p RetryPolicy) allows(wait time.Duration) bool {
+	if wait > p.MaxBackoff {
+		return false
+	}
+	return p.Deadline.IsZero() || time.Now().Add(wait).Before(p.Deadline)
+}
+
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		wait := date.Sub(time.Now())
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
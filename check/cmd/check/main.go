@@ -3,9 +3,9 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
-	"strconv"
+	"time"
 
 	"github.com/xoebus/go-tracker"
 
@@ -13,70 +13,86 @@ import (
 	"github.com/adamstegman/tracker-git-branch-resource/check"
 )
 
+var stderr io.Writer = resource.NewRedactingWriter(os.Stderr, resource.Source{})
+
 func main() {
+	ctx := resource.ExitOnSignal()
+	defer resource.RunCleanups()
+
 	var request check.Request
-	err := json.NewDecoder(os.Stdin).Decode(&request)
+	err := resource.DecodeRequest(os.Stdin, &request)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not parse input: %s\n", err)
+		resource.Exit(1)
+	}
+	stderr = resource.NewRedactingWriter(os.Stderr, request.Source)
+	err = request.Source.Validate()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not parse input: %s\n", err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "%s\n", err)
+		resource.Exit(1)
 	}
 
-	targetDir := filepath.Join(os.Getenv("TMPDIR"), "tracker-git-branch-resource-repo-cache")
-	var keyFile string
-	if request.Source.PrivateKey != "" {
-		keyFile, err = resource.CreateKeyFile(request.Source.PrivateKey)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not create keyfile: %s", err)
-			os.Exit(1)
-		}
-		defer os.Remove(keyFile)
+	targetDir := resource.CacheDir()
+	repository, err := resource.NewSourceRepository(ctx, request.Source, targetDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not set up repo %s: %s\n", request.Source.Repo, err)
+		resource.Exit(1)
 	}
-	repository := resource.NewRepository(request.Source.Repo, targetDir, keyFile)
 	err = repository.Clone()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not clone repo %s: %s\n", request.Source.Repo, err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Could not clone repo %s: %s\n", request.Source.Repo, err)
+		resource.Exit(1)
 	}
 
-	if request.Source.TrackerURL != "" {
-		tracker.DefaultURL = request.Source.TrackerURL
+	trackerClient, err := resource.NewTrackerClient(ctx, request.Source)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not set up Tracker client: %s\n", err)
+		resource.Exit(1)
 	}
-	trackerToken := request.Source.Token
 	stories := []tracker.Story{}
 	for _, projectID := range request.Source.Projects {
-		trackerProjectID, err := strconv.Atoi(projectID)
+		trackerProjectID, err := projectID.Int()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid Tracker project ID %s: %s\n", projectID, err)
-			os.Exit(1)
+			fmt.Fprintf(stderr, "Invalid Tracker project ID %s: %s\n", projectID, err)
+			resource.Exit(1)
 		}
-		projectClient := tracker.NewClient(trackerToken).InProject(trackerProjectID)
-
-		finishedQuery := tracker.StoriesQuery{State: tracker.StoryStateFinished}
-		finishedStories, err := projectClient.Stories(finishedQuery)
+		projectStories, err := check.FetchStories(trackerClient.InProject(trackerProjectID), trackerProjectID, request.Source, stderr)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not fetch finished stories: %s\n", err)
-			os.Exit(1)
+			fmt.Fprintf(stderr, "%s\n", err)
+			resource.Exit(1)
 		}
-		stories = append(stories, finishedStories...)
-		deliveredQuery := tracker.StoriesQuery{State: tracker.StoryStateDelivered}
-		deliveredStories, err := projectClient.Stories(deliveredQuery)
+		stories = append(stories, projectStories...)
+	}
+
+	commitFilter, err := resource.NewCommitFilter(request.Source)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		resource.Exit(1)
+	}
+	trackerGitBranchCheck := check.NewTrackerGitBranchCheck(request.Version, repository, stories, commitFilter).
+		WithBaseBranch(request.Source.BaseBranch).
+		WithVersionMode(request.Source.VersionMode).
+		WithLog(stderr)
+	if request.Source.MaxBranchAge != "" {
+		maxBranchAge, err := time.ParseDuration(request.Source.MaxBranchAge)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not fetch delivered stories: %s\n", err)
-			os.Exit(1)
+			fmt.Fprintf(stderr, "Invalid max_branch_age %s: %s\n", request.Source.MaxBranchAge, err)
+			resource.Exit(1)
 		}
-		stories = append(stories, deliveredStories...)
+		trackerGitBranchCheck = trackerGitBranchCheck.WithMaxBranchAge(maxBranchAge, time.Now())
+	}
+	if request.Source.MaxVersions != nil {
+		trackerGitBranchCheck = trackerGitBranchCheck.WithMaxVersions(*request.Source.MaxVersions, request.Source.MaxVersionsScope)
 	}
-
-	trackerGitBranchCheck := check.NewTrackerGitBranchCheck(request.Version, repository, stories)
 	versions, err := trackerGitBranchCheck.NewVersions()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not find versions: %s\n", err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Could not find versions: %s\n", err)
+		resource.Exit(1)
 	}
 
 	err = json.NewEncoder(os.Stdout).Encode(versions)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not print response: %s\n", err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Could not print response: %s\n", err)
+		resource.Exit(1)
 	}
 }
@@ -0,0 +1,240 @@
+package check
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/xoebus/go-tracker"
+
+	"github.com/adamstegman/tracker-git-branch-resource"
+)
+
+// FetchStories finds the stories in the project that the source asks to be
+// built: those matching its search or, without one, the finished and delivered
+// stories. Anything worth knowing about the results is noted on log.
+func FetchStories(projectClient tracker.ProjectClient, projectID int, source resource.Source, log io.Writer) ([]tracker.Story, error) {
+	owners, requesters, err := resolvePeople(projectClient, projectID, source)
+	if err != nil {
+		return []tracker.Story{}, err
+	}
+	iterationStories, err := iterationStoryIDs(projectClient, projectID, source)
+	if err != nil {
+		return []tracker.Story{}, err
+	}
+	epicLabels, err := epicLabelNames(projectClient, projectID, source)
+	if err != nil {
+		return []tracker.Story{}, err
+	}
+
+	var candidates []tracker.Story
+	if source.Search != "" {
+		candidates, err = searchStories(projectClient, projectID, source, log)
+	} else {
+		candidates, err = stateStories(projectClient, projectID, source)
+	}
+	if err != nil {
+		return []tracker.Story{}, err
+	}
+
+	stories := []tracker.Story{}
+	seen := map[int]bool{}
+	for _, story := range candidates {
+		if seen[story.ID] || hasAnyLabel(story, source.ExcludeLabels) {
+			continue
+		}
+		if len(source.StoryTypes) > 0 && !hasType(story, source.StoryTypes) {
+			continue
+		}
+		if len(owners) > 0 && !hasAnyOwner(story, owners) {
+			continue
+		}
+		if len(requesters) > 0 && !requesters[story.RequestedByID] {
+			continue
+		}
+		if iterationStories != nil && !iterationStories[story.ID] {
+			continue
+		}
+		if epicLabels != nil && !hasAnyLabel(story, epicLabels) {
+			continue
+		}
+		seen[story.ID] = true
+		stories = append(stories, story)
+	}
+	return stories, nil
+}
+
+// stateStories finds the finished and delivered stories with any of the
+// source's labels.
+func stateStories(projectClient tracker.ProjectClient, projectID int, source resource.Source) ([]tracker.Story, error) {
+	// Tracker only filters by one label at a time, so each is queried separately
+	labels := source.Labels
+	if len(labels) == 0 {
+		labels = []string{""}
+	}
+
+	stories := []tracker.Story{}
+	for _, state := range []tracker.StoryState{tracker.StoryStateFinished, tracker.StoryStateDelivered} {
+		for _, label := range labels {
+			query := tracker.StoriesQuery{State: state, Label: label}
+			stateStories, err := projectClient.Stories(query)
+			if err != nil {
+				return []tracker.Story{}, fmt.Errorf("Could not fetch %s stories in project %d: %s", state, projectID, err)
+			}
+			stories = append(stories, stateStories...)
+		}
+	}
+	return stories, nil
+}
+
+// searchStories finds the stories matching the source's Tracker search, in
+// place of the state queries, that have any of the source's labels. Tracker
+// caps how many stories a search returns, so a truncated result is noted.
+func searchStories(projectClient tracker.ProjectClient, projectID int, source resource.Source, log io.Writer) ([]tracker.Story, error) {
+	result, err := projectClient.Search(source.Search)
+	if err != nil {
+		if apiError, ok := err.(*tracker.APIError); ok && apiError.StatusCode == http.StatusBadRequest {
+			return []tracker.Story{}, fmt.Errorf("Tracker rejected the search %q in project %d: %s", source.Search, projectID, err)
+		}
+		return []tracker.Story{}, fmt.Errorf("Could not search for %q in project %d: %s", source.Search, projectID, err)
+	}
+	if result.Stories.TotalHits > len(result.Stories.Stories) {
+		fmt.Fprintf(log, "Warning: the search %q in project %d matched %d stories, but Tracker only returned %d; narrow the search so none are left out\n", source.Search, projectID, result.Stories.TotalHits, len(result.Stories.Stories))
+	}
+	if len(source.Labels) == 0 {
+		return result.Stories.Stories, nil
+	}
+	stories := []tracker.Story{}
+	for _, story := range result.Stories.Stories {
+		if hasAnyLabel(story, source.Labels) {
+			stories = append(stories, story)
+		}
+	}
+	return stories, nil
+}
+
+// resolvePeople finds the IDs of the owners and requesters to filter by,
+// looking up any usernames in the project's memberships.
+func resolvePeople(projectClient tracker.ProjectClient, projectID int, source resource.Source) (map[int]bool, map[int]bool, error) {
+	var usernameIDs map[string]int
+	resolve := func(people []resource.Person) (map[int]bool, error) {
+		ids := map[int]bool{}
+		for _, person := range people {
+			if id, ok := person.ID(); ok {
+				ids[id] = true
+				continue
+			}
+			if usernameIDs == nil {
+				memberships, err := projectClient.ProjectMemberships()
+				if err != nil {
+					return nil, fmt.Errorf("Could not fetch memberships of project %d: %s", projectID, err)
+				}
+				usernameIDs = map[string]int{}
+				for _, membership := range memberships {
+					usernameIDs[strings.ToLower(membership.Person.Username)] = membership.Person.ID
+				}
+			}
+			username := strings.ToLower(strings.TrimPrefix(string(person), "@"))
+			id, ok := usernameIDs[username]
+			if !ok {
+				return nil, fmt.Errorf("No member of project %d has the username %s", projectID, person)
+			}
+			ids[id] = true
+		}
+		return ids, nil
+	}
+
+	owners, err := resolve(source.OwnedBy)
+	if err != nil {
+		return nil, nil, err
+	}
+	requesters, err := resolve(source.RequestedBy)
+	if err != nil {
+		return nil, nil, err
+	}
+	return owners, requesters, nil
+}
+
+// iterationsPageSize is how many iterations are asked for at a time; Tracker
+// pages through the backlog and done iterations rather than returning them all.
+const iterationsPageSize = 10
+
+// iterationStoryIDs finds the stories in the source's iteration, or returns
+// nil if it doesn't restrict stories to an iteration.
+func iterationStoryIDs(projectClient tracker.ProjectClient, projectID int, source resource.Source) (map[int]bool, error) {
+	if source.Iteration == "" {
+		return nil, nil
+	}
+	scope := tracker.IterationScope(source.Iteration)
+	ids := map[int]bool{}
+	for offset := 0; ; offset += iterationsPageSize {
+		query := tracker.IterationsQuery{Scope: scope}
+		if scope != tracker.IterationScopeCurrent {
+			query.Limit = iterationsPageSize
+			query.Offset = offset
+		}
+		iterations, err := projectClient.Iterations(query)
+		if err != nil {
+			return nil, fmt.Errorf("Could not fetch %s iterations in project %d: %s", source.Iteration, projectID, err)
+		}
+		for _, iteration := range iterations {
+			for _, story := range iteration.Stories {
+				ids[story.ID] = true
+			}
+		}
+		if query.Limit == 0 || len(iterations) < query.Limit {
+			return ids, nil
+		}
+	}
+}
+
+// epicLabelNames finds the labels of the source's epic, or returns nil if it
+// doesn't restrict stories to an epic. A story is under an epic when it has
+// the epic's label, so a project without the epic has no labels to match.
+func epicLabelNames(projectClient tracker.ProjectClient, projectID int, source resource.Source) ([]string, error) {
+	if source.Epic == "" {
+		return nil, nil
+	}
+	epics, err := projectClient.Epics()
+	if err != nil {
+		return nil, fmt.Errorf("Could not fetch epics in project %d: %s", projectID, err)
+	}
+	labels := []string{}
+	for _, epic := range epics {
+		if strings.EqualFold(epic.Name, source.Epic) || strconv.Itoa(epic.ID) == source.Epic {
+			labels = append(labels, epic.Label.Name)
+		}
+	}
+	return labels, nil
+}
+
+func hasAnyLabel(story tracker.Story, labels []string) bool {
+	for _, storyLabel := range story.Labels {
+		for _, label := range labels {
+			if strings.EqualFold(storyLabel.Name, label) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasType(story tracker.Story, storyTypes []string) bool {
+	for _, storyType := range storyTypes {
+		if string(story.Type) == storyType {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyOwner(story tracker.Story, owners map[int]bool) bool {
+	for _, ownerID := range story.OwnerIDs {
+		if owners[ownerID] {
+			return true
+		}
+	}
+	return false
+}
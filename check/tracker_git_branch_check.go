@@ -2,14 +2,21 @@ package check
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/xoebus/go-tracker"
 
 	"github.com/adamstegman/tracker-git-branch-resource"
 )
 
+// Without a base branch, story branches are taken to be cut from the remote's
+// default branch.
+const defaultBase = "origin/HEAD"
+
 type TrackerGitBranchCheck interface {
 	NewVersions() ([]resource.Version, error)
 }
@@ -18,18 +25,73 @@ type trackerGitBranchCheck struct {
 	startingVersion resource.Version
 	repository      resource.Repository
 	stories         []tracker.Story
+	filter          resource.CommitFilter
+	base            string
+
+	versionMode      string
+	maxVersions      int
+	maxVersionsScope string
+	maxBranchAge     time.Duration
+	now              time.Time
+	log              io.Writer
 }
 
 func NewTrackerGitBranchCheck(
 	startingVersion resource.Version,
 	repository resource.Repository,
 	stories []tracker.Story,
+	filter resource.CommitFilter,
 ) trackerGitBranchCheck {
 	return trackerGitBranchCheck{
 		startingVersion: startingVersion,
 		repository:      repository,
 		stories:         stories,
+		filter:          filter,
+		base:            defaultBase,
+		versionMode:     resource.VersionModeEvery,
+		log:             ioutil.Discard,
+	}
+}
+
+// WithLog returns a copy of the check that notes the versions it leaves out
+// on log.
+func (c trackerGitBranchCheck) WithLog(log io.Writer) trackerGitBranchCheck {
+	c.log = log
+	return c
+}
+
+// WithBaseBranch returns a copy of the check that only builds commits on story
+// branches that aren't on the given base branch.
+func (c trackerGitBranchCheck) WithBaseBranch(branch string) trackerGitBranchCheck {
+	if branch != "" {
+		c.base = "origin/" + branch
 	}
+	return c
+}
+
+// WithVersionMode returns a copy of the check that emits the new commits the
+// mode asks for: every one, the latest on each branch, or the latest overall.
+func (c trackerGitBranchCheck) WithVersionMode(mode string) trackerGitBranchCheck {
+	if mode != "" {
+		c.versionMode = mode
+	}
+	return c
+}
+
+// WithMaxBranchAge returns a copy of the check that skips story branches whose
+// tip was committed more than maxAge before now.
+func (c trackerGitBranchCheck) WithMaxBranchAge(maxAge time.Duration, now time.Time) trackerGitBranchCheck {
+	c.maxBranchAge = maxAge
+	c.now = now
+	return c
+}
+
+// WithMaxVersions returns a copy of the check that emits only the newest max
+// versions, either overall or for each story.
+func (c trackerGitBranchCheck) WithMaxVersions(max int, scope string) trackerGitBranchCheck {
+	c.maxVersions = max
+	c.maxVersionsScope = scope
+	return c
 }
 
 func (c trackerGitBranchCheck) NewVersions() ([]resource.Version, error) {
@@ -56,7 +118,7 @@ func (c trackerGitBranchCheck) NewVersions() ([]resource.Version, error) {
 		}
 	}
 
-	return versions, nil
+	return c.capVersions(versions), nil
 }
 
 func (c trackerGitBranchCheck) latestStoryBranchRef(remoteBranches []string) ([]resource.Version, error) {
@@ -65,16 +127,26 @@ func (c trackerGitBranchCheck) latestStoryBranchRef(remoteBranches []string) ([]
 	for _, story := range c.stories {
 		for _, branch := range remoteBranches {
 			if isStoryBranch(branch, story) {
-				timestamp, err := c.repository.RefCommitTimestamp(branch)
+				stale, err := c.isStale(branch)
 				if err != nil {
-					return []resource.Version{}, fmt.Errorf("Could not get ref commit timestamp for %s: %s", branch, err)
+					return []resource.Version{}, err
+				}
+				if stale {
+					break
+				}
+				ref, err := c.repository.LatestMatchingRef(branch, c.base, c.filter)
+				if err != nil {
+					return []resource.Version{}, fmt.Errorf("Could not get latest SHA for %s: %s", branch, err)
+				}
+				if ref == "" {
+					break
+				}
+				timestamp, err := c.repository.RefCommitTimestamp(ref)
+				if err != nil {
+					return []resource.Version{}, fmt.Errorf("Could not get ref commit timestamp for %s: %s", ref, err)
 				}
 
 				if timestamp > latestTime {
-					ref, err := c.repository.LatestRef(branch)
-					if err != nil {
-						return []resource.Version{}, fmt.Errorf("Could not get latest SHA for %s: %s", branch, err)
-					}
 					versions = []resource.Version{{StoryID: strconv.Itoa(story.ID), Ref: ref, Timestamp: strconv.FormatInt(timestamp, 10)}}
 					latestTime = timestamp
 				}
@@ -91,16 +163,24 @@ func (c trackerGitBranchCheck) storyBranchRefsSinceStartingVersion(remoteBranche
 	for _, story := range c.stories {
 		for _, branch := range remoteBranches {
 			if isStoryBranch(branch, story) {
+				stale, err := c.isStale(branch)
+				if err != nil {
+					return []resource.Version{}, err
+				}
+				if stale {
+					break
+				}
 				timestamp, err := strconv.ParseInt(c.startingVersion.Timestamp, 10, 64)
 				if err != nil {
 					return []resource.Version{}, fmt.Errorf("Could not get parse time %s: %s", c.startingVersion.Timestamp, err)
 				}
-				refs, err := c.repository.RefsSinceTimestamp(branch, timestamp)
+				refs, err := c.repository.RefsSinceTimestamp(branch, c.base, timestamp, c.filter)
 				if err != nil {
-					return []resource.Version{}, fmt.Errorf("Could not get refs since time %s for %s: %s", timestamp, branch, err)
+					return []resource.Version{}, fmt.Errorf("Could not get refs since time %d for %s: %s", timestamp, branch, err)
 				}
 
 				// Collect versions for later sorting
+				branchVersions := []resource.Version{}
 				for _, ref := range refs {
 					ref = strings.Trim(ref, "\"")
 					if ref != "" && ref != c.startingVersion.Ref {
@@ -108,17 +188,97 @@ func (c trackerGitBranchCheck) storyBranchRefsSinceStartingVersion(remoteBranche
 						if err != nil {
 							return []resource.Version{}, fmt.Errorf("Could not get timestamp for %s: %s", ref, err)
 						}
-						versions = append(versions, resource.Version{StoryID: strconv.Itoa(story.ID), Ref: ref, Timestamp: strconv.FormatInt(timestamp, 10)})
+						branchVersions = append(branchVersions, resource.Version{StoryID: strconv.Itoa(story.ID), Ref: ref, Timestamp: strconv.FormatInt(timestamp, 10)})
 					}
 				}
+				if c.versionMode == resource.VersionModeEvery {
+					versions = append(versions, branchVersions...)
+				} else {
+					versions = append(versions, latestVersions(branchVersions)...)
+				}
 
 				break
 			}
 		}
 	}
+	if c.versionMode == resource.VersionModeLatest {
+		versions = latestVersions(versions)
+	}
 	return sortVersionsByTimestamp(versions), nil
 }
 
+// isStale reports whether the branch's tip is older than the max branch age.
+func (c trackerGitBranchCheck) isStale(branch string) (bool, error) {
+	if c.maxBranchAge == 0 {
+		return false, nil
+	}
+	timestamp, err := c.repository.RefCommitTimestamp(branch)
+	if err != nil {
+		return false, fmt.Errorf("Could not get tip commit timestamp for %s: %s", branch, err)
+	}
+	age := c.now.Sub(time.Unix(timestamp, 0))
+	if age <= c.maxBranchAge {
+		return false, nil
+	}
+	fmt.Fprintf(c.log, "Skipping %s: its last commit is %s old, older than max_branch_age %s\n", branch, age.Truncate(time.Second), c.maxBranchAge)
+	return true, nil
+}
+
+// capVersions drops all but the newest max versions, overall or for each
+// story, from the chronologically sorted versions.
+func (c trackerGitBranchCheck) capVersions(versions []resource.Version) []resource.Version {
+	if c.maxVersions == 0 {
+		return versions
+	}
+
+	// Walk from newest to oldest, counting what each scope has kept
+	kept := map[string]int{}
+	keep := make([]bool, len(versions))
+	dropped := 0
+	for i := len(versions) - 1; i >= 0; i-- {
+		scope := ""
+		if c.maxVersionsScope == resource.MaxVersionsScopeStory {
+			scope = versions[i].StoryID
+		}
+		if kept[scope] < c.maxVersions {
+			kept[scope]++
+			keep[i] = true
+		} else {
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		return versions
+	}
+
+	capped := []resource.Version{}
+	for i, version := range versions {
+		if keep[i] {
+			capped = append(capped, version)
+		}
+	}
+	if c.maxVersionsScope == resource.MaxVersionsScopeStory {
+		fmt.Fprintf(c.log, "Dropped %d older versions to keep the newest %d per story (max_versions)\n", dropped, c.maxVersions)
+	} else {
+		fmt.Fprintf(c.log, "Dropped %d older versions to keep the newest %d (max_versions)\n", dropped, c.maxVersions)
+	}
+	return capped
+}
+
+// latestVersions returns the most recently committed of the versions, if any.
+func latestVersions(versions []resource.Version) []resource.Version {
+	if len(versions) == 0 {
+		return []resource.Version{}
+	}
+	latest := versions[0]
+	for _, version := range versions[1:] {
+		if version.Timestamp > latest.Timestamp {
+			latest = version
+		}
+	}
+	return []resource.Version{latest}
+}
+
 func isStoryBranch(branch string, story tracker.Story) bool {
 	return strings.Contains(branch, strconv.Itoa(story.ID))
 }
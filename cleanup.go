@@ -0,0 +1,61 @@
+package resource
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How long main has to exit by itself after being signalled.
+const signalGracePeriod = 10 * time.Second
+
+var (
+	cleanupLock sync.Mutex
+	cleanups    []func()
+)
+
+// AddCleanup registers f to be run, in reverse order of registration, by
+// RunCleanups, Exit, or when the process is interrupted.
+func AddCleanup(f func()) {
+	cleanupLock.Lock()
+	defer cleanupLock.Unlock()
+	cleanups = append(cleanups, f)
+}
+
+func RunCleanups() {
+	cleanupLock.Lock()
+	defer cleanupLock.Unlock()
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	cleanups = nil
+}
+
+// Exit runs the cleanups before exiting, since os.Exit skips deferred calls.
+func Exit(code int) {
+	RunCleanups()
+	os.Exit(code)
+}
+
+// ExitOnSignal returns a context that is cancelled when the process is
+// interrupted or terminated, so that running commands and requests are
+// stopped. If the process hasn't exited within a grace period, or is signalled
+// again, it runs the cleanups and exits non-zero itself.
+func ExitOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+		select {
+		case <-signals:
+		case <-time.After(signalGracePeriod):
+		}
+		Exit(1)
+	}()
+	return ctx
+}
@@ -0,0 +1,24 @@
+package resource
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// How long a cancelled command has to release its output before Wait gives up
+// on it.
+const commandWaitDelay = time.Second
+
+// command runs name in its own process group, so that when ctx is done
+// everything it started, such as ssh or a remote helper, is killed with it.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
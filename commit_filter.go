@@ -0,0 +1,117 @@
+package resource
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Commits whose message asks for them to be skipped, unless disable_ci_skip
+// is set.
+var ciSkipPattern = regexp.MustCompile(`(?i)\[(ci skip|skip ci)\]`)
+
+// CommitFilter narrows the commits on story branches that check builds.
+type CommitFilter struct {
+	// Commits must change a file matching one of Paths, if any are given,
+	// other than a file matching one of IgnorePaths.
+	Paths       []string
+	IgnorePaths []string
+
+	CommitMessage       *regexp.Regexp
+	IgnoreCommitMessage *regexp.Regexp
+	SkipCI              bool
+
+	// People are matched by name or email.
+	Authors          []string
+	IgnoreAuthors    []string
+	Committers       []string
+	IgnoreCommitters []string
+
+	// Commits must be signed by a trusted key.
+	RequireSignature bool
+}
+
+func NewCommitFilter(source Source) (CommitFilter, error) {
+	filter := CommitFilter{
+		Paths:            source.Paths,
+		IgnorePaths:      source.IgnorePaths,
+		SkipCI:           !source.DisableCISkip,
+		Authors:          source.Authors,
+		IgnoreAuthors:    source.IgnoreAuthors,
+		Committers:       source.Committers,
+		IgnoreCommitters: source.IgnoreCommitters,
+		RequireSignature: len(source.CommitVerificationKeys) > 0,
+	}
+	var err error
+	if source.CommitMessage != "" {
+		filter.CommitMessage, err = regexp.Compile(source.CommitMessage)
+		if err != nil {
+			return CommitFilter{}, fmt.Errorf("Invalid commit_message %s: %s", source.CommitMessage, err)
+		}
+	}
+	if source.IgnoreCommitMessage != "" {
+		filter.IgnoreCommitMessage, err = regexp.Compile(source.IgnoreCommitMessage)
+		if err != nil {
+			return CommitFilter{}, fmt.Errorf("Invalid ignore_commit_message %s: %s", source.IgnoreCommitMessage, err)
+		}
+	}
+	return filter, nil
+}
+
+// Matches is whether the commit passes the filters that git log can't apply
+// itself.
+func (f CommitFilter) Matches(commit Commit) bool {
+	if f.RequireSignature && !commit.Signature.Trusted() {
+		return false
+	}
+	if f.SkipCI && ciSkipPattern.MatchString(commit.Message) {
+		return false
+	}
+	if f.CommitMessage != nil && !f.CommitMessage.MatchString(commit.Message) {
+		return false
+	}
+	if f.IgnoreCommitMessage != nil && f.IgnoreCommitMessage.MatchString(commit.Message) {
+		return false
+	}
+	if len(f.Authors) > 0 && !matchesPerson(f.Authors, commit.AuthorName, commit.AuthorEmail) {
+		return false
+	}
+	if matchesPerson(f.IgnoreAuthors, commit.AuthorName, commit.AuthorEmail) {
+		return false
+	}
+	if len(f.Committers) > 0 && !matchesPerson(f.Committers, commit.CommitterName, commit.CommitterEmail) {
+		return false
+	}
+	if matchesPerson(f.IgnoreCommitters, commit.CommitterName, commit.CommitterEmail) {
+		return false
+	}
+	return true
+}
+
+func matchesPerson(people []string, name string, email string) bool {
+	for _, person := range people {
+		if strings.EqualFold(person, name) || strings.EqualFold(person, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// logArgs are the git log arguments that select the commits passing the
+// filter. They have to come after any revisions.
+func (f CommitFilter) logArgs() []string {
+	if len(f.Paths) == 0 && len(f.IgnorePaths) == 0 {
+		return []string{}
+	}
+	args := []string{"--"}
+	for _, path := range f.Paths {
+		args = append(args, ":(glob)"+path)
+	}
+	if len(f.Paths) == 0 {
+		args = append(args, ".")
+	}
+	for _, path := range f.IgnorePaths {
+		args = append(args, ":(exclude,glob)"+path)
+	}
+	return args
+}
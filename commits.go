@@ -0,0 +1,156 @@
+package resource
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fields are separated by NUL and commits by RS, neither of which can appear
+// in a commit message.
+// The signature fields are only asked for when needed, as git checks every
+// signed commit's signature to fill them in.
+const (
+	commitFieldSeparator = "\x00"
+	commitSeparator      = '\x1e'
+	commitLogFormat      = "--format=%H%x00%ct%x00%an%x00%ae%x00%cn%x00%ce%x00%B%x1e"
+	signedCommitFormat   = "--format=%H%x00%ct%x00%an%x00%ae%x00%cn%x00%ce%x00%G?%x00%GS%x00%GF%x00%B%x1e"
+)
+
+type Commit struct {
+	Ref            string
+	Timestamp      int64
+	AuthorName     string
+	AuthorEmail    string
+	CommitterName  string
+	CommitterEmail string
+	Signature      Signature
+	Message        string
+}
+
+// LatestMatchingRef finds the latest commit on the branch, but not on base,
+// that passes the filter, or returns an empty string if none do.
+func (r Repository) LatestMatchingRef(branch string, base string, filter CommitFilter) (string, error) {
+	ref := ""
+	err := r.logCommits([]string{branch, "^" + base}, filter, func(commit Commit) bool {
+		ref = commit.Ref
+		return false
+	})
+	if err != nil {
+		return "", fmt.Errorf("Could not find latest matching ref for %s: %s", branch, err)
+	}
+	return ref, nil
+}
+
+// RefsSinceTimestamp lists the commits on the branch, but not on base, since
+// the timestamp that pass the filter, newest first.
+func (r Repository) RefsSinceTimestamp(branch string, base string, timestamp int64, filter CommitFilter) ([]string, error) {
+	refs := []string{}
+	err := r.logCommits([]string{fmt.Sprintf("--since=%d", timestamp), branch, "^" + base}, filter, func(commit Commit) bool {
+		refs = append(refs, commit.Ref)
+		return true
+	})
+	if err != nil {
+		return []string{}, fmt.Errorf("Could not list refs since %d for %s: %s", timestamp, branch, err)
+	}
+	return refs, nil
+}
+
+// logCommits calls visit with each commit git log lists that passes the
+// filter, until visit returns false.
+func (r Repository) logCommits(args []string, filter CommitFilter, visit func(Commit) bool) error {
+	format := commitLogFormat
+	if filter.RequireSignature {
+		format = signedCommitFormat
+	}
+	args = append(append([]string{"log", format}, args...), filter.logArgs()...)
+	cmd := command(r.ctx, "git", args...)
+	cmd.Env = r.env()
+	cmd.Dir = r.dir
+	var errBytes bytes.Buffer
+	cmd.Stderr = &errBytes
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("git %v in %s failed: %s", args, r.dir, err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	scanner.Split(splitCommits)
+	stopped := false
+	for scanner.Scan() {
+		commit, err := parseCommit(scanner.Text(), filter.RequireSignature)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+		if filter.Matches(commit) && !visit(commit) {
+			stopped = true
+			break
+		}
+	}
+	if stopped {
+		// git only fails from here on because its output is no longer read
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("Could not read git log output: %s", err)
+	}
+	err = cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("git %v in %s failed: %s\n[STDERR]\n%s", args, r.dir, err, errBytes.String())
+	}
+	return nil
+}
+
+func splitCommits(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, commitSeparator); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		if len(bytes.TrimSpace(data)) == 0 {
+			return len(data), nil, nil
+		}
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func parseCommit(record string, signed bool) (Commit, error) {
+	fieldCount := 7
+	if signed {
+		fieldCount = 10
+	}
+	fields := strings.SplitN(strings.TrimLeft(record, "\n"), commitFieldSeparator, fieldCount)
+	if len(fields) != fieldCount {
+		return Commit{}, fmt.Errorf("Could not parse git log entry %q", record)
+	}
+	timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Commit{}, fmt.Errorf("Could not parse committer timestamp (%s) for %s: %s", fields[1], fields[0], err)
+	}
+	commit := Commit{
+		Ref:            fields[0],
+		Timestamp:      timestamp,
+		AuthorName:     fields[2],
+		AuthorEmail:    fields[3],
+		CommitterName:  fields[4],
+		CommitterEmail: fields[5],
+		Message:        strings.TrimSpace(fields[fieldCount-1]),
+	}
+	if signed {
+		commit.Signature = Signature{Status: fields[6], Signer: fields[7], Key: fields[8]}
+	}
+	return commit, nil
+}
@@ -0,0 +1,90 @@
+package resource
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// systemCABundle is where the image collects the system's certificate
+// authorities, so they can be trusted by git alongside ca_certs.
+const systemCABundle = "/etc/ssl/certs/ca-certificates.crt"
+
+// NewHTTPClient builds a client for talking to Tracker through the source's
+// proxy, trusting its certificate authorities.
+func NewHTTPClient(source Source) (*http.Client, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+
+	if source.Proxy != "" {
+		proxyURL, err := url.Parse(source.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy %s: %s", source.Proxy, err)
+		}
+		transport.Proxy = func(request *http.Request) (*url.URL, error) {
+			if bypassesProxy(request.URL.Hostname(), source.NoProxy) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	}
+
+	if source.CACerts != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(source.CACerts)) {
+			return nil, fmt.Errorf("Could not find any PEM certificates in ca_certs")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// bypassesProxy matches host against a comma-separated no_proxy list of host
+// names, domain suffixes, or "*".
+func bypassesProxy(host string, noProxy string) bool {
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.TrimSpace(entry)
+		if entryHost, _, err := net.SplitHostPort(entry); err == nil {
+			entry = entryHost
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if entry == "" {
+			continue
+		}
+		if entry == "*" || host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateCAInfoFile writes the given certificates, followed by the system's, for
+// git's http.sslCAInfo.
+func CreateCAInfoFile(caCerts string) (string, error) {
+	caInfo := strings.TrimSpace(caCerts) + "\n"
+	systemCAs, err := ioutil.ReadFile(systemCABundle)
+	if err == nil {
+		caInfo = caInfo + string(systemCAs)
+	}
+
+	caInfoFile, err := ioutil.TempFile("", "tracker-git-branch-resource-ca-certs")
+	if err != nil {
+		return "", fmt.Errorf("Could not create CA certificates file: %s", err)
+	}
+	defer caInfoFile.Close()
+	_, err = caInfoFile.WriteString(caInfo)
+	if err != nil {
+		return "", fmt.Errorf("Could not write CA certificates file %s: %s", caInfoFile.Name(), err)
+	}
+	return caInfoFile.Name(), nil
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/xoebus/go-tracker"
 
@@ -11,61 +16,186 @@ import (
 	"github.com/adamstegman/tracker-git-branch-resource/in"
 )
 
+const (
+	defaultBaseBranch = "master"
+	integrateMerge    = "merge"
+	integrateRebase   = "rebase"
+
+	verifyFail = "fail"
+	verifyWarn = "warn"
+
+	bundleFileName   = "story.bundle"
+	manifestFileName = "manifest.json"
+)
+
+var stderr io.Writer = resource.NewRedactingWriter(os.Stderr, resource.Source{})
+
 func main() {
+	ctx := resource.ExitOnSignal()
+	defer resource.RunCleanups()
+
 	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage: %s <target directory>\n", os.Args[0])
-		os.Exit(1)
+		fmt.Fprintf(stderr, "usage: %s <target directory>\n", os.Args[0])
+		resource.Exit(1)
 	}
 	targetDir := os.Args[1]
 
 	var request in.InRequest
-	err := json.NewDecoder(os.Stdin).Decode(&request)
+	err := resource.DecodeRequest(os.Stdin, &request)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not parse input: %s\n", err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Could not parse input: %s\n", err)
+		resource.Exit(1)
+	}
+	stderr = resource.NewRedactingWriter(os.Stderr, request.Source)
+	err = request.Source.Validate()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		resource.Exit(1)
+	}
+	trackerURL, err := resource.TrackerURL(request.Source)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		resource.Exit(1)
+	}
+	if request.Params.Bundle != "" && !isWithinCheckout(request.Params.Bundle) {
+		fmt.Fprintf(stderr, "Invalid bundle param %s: must be a directory inside the checkout\n", request.Params.Bundle)
+		resource.Exit(1)
 	}
 
-	var keyFile string
-	if request.Source.PrivateKey != "" {
-		keyFile, err = resource.CreateKeyFile(request.Source.PrivateKey)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not create keyfile: %s", err)
-			os.Exit(1)
-		}
-		defer os.Remove(keyFile)
+	repository, err := resource.NewSourceRepository(ctx, request.Source, targetDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not set up repo %s: %s\n", request.Source.Repo, err)
+		resource.Exit(1)
+	}
+	repository = repository.WithReference(resource.CacheDir())
+	if len(request.Params.SparseCheckout) > 0 {
+		err = repository.PartialClone(request.Params.SparseCheckout)
+	} else {
+		err = repository.Clone()
 	}
-	repository := resource.NewRepository(request.Source.Repo, targetDir, keyFile)
-	err = repository.Clone()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not clone repo %s: %s\n", request.Source.Repo, err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Could not clone repo %s: %s\n", request.Source.Repo, err)
+		resource.Exit(1)
 	}
 	err = repository.Fetch()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not fetch repo %s: %s\n", request.Source.Repo, err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Could not fetch repo %s: %s\n", request.Source.Repo, err)
+		resource.Exit(1)
 	}
-	err = repository.CheckoutRef(request.Version.Ref)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not checkout %s#%s: %s\n", request.Source.Repo, request.Version.Ref, err)
-		os.Exit(1)
+	var signature resource.Signature
+	if len(request.Source.CommitVerificationKeys) > 0 {
+		signature, err = repository.RefSignature(request.Version.Ref)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not verify %s#%s: %s\n", request.Source.Repo, request.Version.Ref, err)
+			resource.Exit(1)
+		}
+		if !signature.Trusted() {
+			fmt.Fprintf(stderr, "Refusing to check out %s#%s: %s\n", request.Source.Repo, request.Version.Ref, signature.Problem())
+			resource.Exit(1)
+		}
+	}
+	var verificationWarning string
+	switch request.Params.Verify {
+	case "":
+	case verifyFail, verifyWarn:
+		problem, err := verifyStoryRef(ctx, request, repository)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not verify %s#%s: %s\n", request.Source.Repo, request.Version.Ref, err)
+			resource.Exit(1)
+		}
+		if problem != "" && request.Params.Verify == verifyFail {
+			fmt.Fprintf(stderr, "Refusing to check out %s#%s: %s\n", request.Source.Repo, request.Version.Ref, problem)
+			resource.Exit(1)
+		}
+		verificationWarning = problem
+	default:
+		fmt.Fprintf(stderr, "Invalid verify param %s: must be %s or %s\n", request.Params.Verify, verifyFail, verifyWarn)
+		resource.Exit(1)
 	}
 
-	metadata, err := metadata(request, repository)
+	baseBranch := request.Params.BaseBranch
+	if baseBranch == "" {
+		baseBranch = request.Source.BaseBranch
+	}
+	if baseBranch == "" {
+		baseBranch = defaultBaseBranch
+	}
+	switch request.Params.Integrate {
+	case "":
+		err = repository.CheckoutRef(request.Version.Ref)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not checkout %s#%s: %s\n", request.Source.Repo, request.Version.Ref, err)
+			resource.Exit(1)
+		}
+	case integrateMerge:
+		err = repository.MergeRef(baseBranch, request.Version.Ref)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not merge %s#%s into %s: %s\n", request.Source.Repo, request.Version.Ref, baseBranch, err)
+			resource.Exit(1)
+		}
+	case integrateRebase:
+		err = repository.RebaseRef(baseBranch, request.Version.Ref)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not rebase %s#%s onto %s: %s\n", request.Source.Repo, request.Version.Ref, baseBranch, err)
+			resource.Exit(1)
+		}
+	default:
+		fmt.Fprintf(stderr, "Invalid integrate param %s: must be %s or %s\n", request.Params.Integrate, integrateMerge, integrateRebase)
+		resource.Exit(1)
+	}
+
+	metadata, err := metadata(request, repository, trackerURL)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not fetch metadata for %s#%s: %s\n", request.Source.Repo, request.Version.Ref, err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Could not fetch metadata for %s#%s: %s\n", request.Source.Repo, request.Version.Ref, err)
+		resource.Exit(1)
+	}
+	if request.Params.Integrate != "" {
+		tree, err := repository.TreeRef("HEAD")
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not fetch integrated tree for %s#%s: %s\n", request.Source.Repo, request.Version.Ref, err)
+			resource.Exit(1)
+		}
+		metadata = append(metadata,
+			resource.MetadataPair{Name: "integrated_onto", Value: baseBranch},
+			resource.MetadataPair{Name: "tree", Value: tree},
+		)
+	}
+
+	if signature.Trusted() {
+		metadata = append(metadata,
+			resource.MetadataPair{Name: "signer", Value: signature.Signer},
+			resource.MetadataPair{Name: "signing_key", Value: signature.Key},
+		)
+	}
+	if verificationWarning != "" {
+		metadata = append(metadata, resource.MetadataPair{Name: "verification_warning", Value: verificationWarning})
+	}
+	if len(request.Params.SparseCheckout) > 0 {
+		patterns, err := repository.SparseCheckoutPatterns()
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not list sparse-checkout patterns for %s: %s\n", request.Source.Repo, err)
+			resource.Exit(1)
+		}
+		metadata = append(metadata, resource.MetadataPair{Name: "sparse_checkout", Value: strings.Join(patterns, "\n")})
+	}
+	if request.Params.Bundle != "" {
+		bundleDir, err := writeBundle(request, repository, targetDir, baseBranch)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not bundle %s#%s: %s\n", request.Source.Repo, request.Version.Ref, err)
+			resource.Exit(1)
+		}
+		metadata = append(metadata, resource.MetadataPair{Name: "bundle", Value: bundleDir})
 	}
 
 	response := in.InResponse{Version: request.Version, Metadata: metadata}
 
 	if err := json.NewEncoder(os.Stdout).Encode(response); err != nil {
-		fmt.Fprintf(os.Stderr, "Could not print response: %s\n", err)
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Could not print response: %s\n", err)
+		resource.Exit(1)
 	}
 }
 
-func metadata(request in.InRequest, repository resource.Repository) ([]resource.MetadataPair, error) {
+func metadata(request in.InRequest, repository resource.Repository, trackerURL string) ([]resource.MetadataPair, error) {
 	authorName, err := repository.RefAuthorName(request.Version.Ref)
 	if err != nil {
 		return []resource.MetadataPair{}, err
@@ -86,10 +216,6 @@ func metadata(request in.InRequest, repository resource.Repository) ([]resource.
 	if err != nil {
 		return []resource.MetadataPair{}, err
 	}
-	trackerURL := request.Source.TrackerURL
-	if trackerURL == "" {
-		trackerURL = tracker.DefaultURL
-	}
 	storyURL := fmt.Sprintf("%s/story/show/%s", trackerURL, request.Version.StoryID)
 	return []resource.MetadataPair{
 		{Name: "commit", Value: request.Version.Ref},
@@ -101,3 +227,111 @@ func metadata(request in.InRequest, repository resource.Repository) ([]resource.
 		{Name: "story_url", Value: storyURL},
 	}, nil
 }
+
+// verifyStoryRef describes why the ref should no longer be built for the
+// story, or returns an empty string if it still should be.
+func verifyStoryRef(ctx context.Context, request in.InRequest, repository resource.Repository) (string, error) {
+	problems := []string{}
+
+	branches, err := repository.RemoteBranchesContaining(request.Version.Ref)
+	if err != nil {
+		return "", err
+	}
+	onStoryBranch := false
+	for _, branch := range branches {
+		if strings.Contains(branch, request.Version.StoryID) {
+			onStoryBranch = true
+			break
+		}
+	}
+	if !onStoryBranch {
+		problems = append(problems, fmt.Sprintf("%s is not on a branch for story %s", request.Version.Ref, request.Version.StoryID))
+	}
+
+	storyID, err := strconv.Atoi(request.Version.StoryID)
+	if err != nil {
+		return "", fmt.Errorf("Invalid Tracker story ID %s: %s", request.Version.StoryID, err)
+	}
+	trackerClient, err := resource.NewTrackerClient(ctx, request.Source)
+	if err != nil {
+		return "", fmt.Errorf("Could not set up Tracker client: %s", err)
+	}
+	story, err := trackerClient.Story(storyID)
+	if err != nil {
+		return "", fmt.Errorf("Could not fetch story %d: %s", storyID, err)
+	}
+	allowedStates := request.Params.VerifyStates
+	if len(allowedStates) == 0 {
+		allowedStates = []string{tracker.StoryStateFinished, tracker.StoryStateDelivered}
+	}
+	allowed := false
+	for _, state := range allowedStates {
+		if string(story.State) == state {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		problems = append(problems, fmt.Sprintf("story %d is %s, not one of %v", storyID, story.State, allowedStates))
+	}
+
+	return strings.Join(problems, "; "), nil
+}
+
+// isWithinCheckout reports whether the relative path names a directory below
+// the checkout, rather than the checkout itself or somewhere outside it.
+func isWithinCheckout(path string) bool {
+	if filepath.IsAbs(path) || filepath.Clean(path) == "." {
+		return false
+	}
+	for _, element := range strings.Split(filepath.ToSlash(path), "/") {
+		if element == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func writeBundle(request in.InRequest, repository resource.Repository, targetDir string, baseBranch string) (string, error) {
+	bundleDir, err := filepath.Abs(filepath.Join(targetDir, request.Params.Bundle))
+	if err != nil {
+		return "", fmt.Errorf("Could not resolve bundle directory %s: %s", request.Params.Bundle, err)
+	}
+	err = os.MkdirAll(bundleDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("Could not create bundle directory %s: %s", bundleDir, err)
+	}
+	// The bundle isn't part of the story, so tasks shouldn't see it as a change
+	err = repository.Exclude(filepath.Clean(request.Params.Bundle))
+	if err != nil {
+		return "", err
+	}
+
+	bundleBranch := fmt.Sprintf("tracker/%s", request.Version.StoryID)
+	err = repository.CreateBundle(filepath.Join(bundleDir, bundleFileName), bundleBranch, request.Version.Ref, baseBranch)
+	if err != nil {
+		return "", err
+	}
+	bundleRef := "refs/heads/" + bundleBranch
+	baseRef, err := repository.LatestRef("origin/" + baseBranch)
+	if err != nil {
+		return "", err
+	}
+
+	manifestFile, err := os.Create(filepath.Join(bundleDir, manifestFileName))
+	if err != nil {
+		return "", fmt.Errorf("Could not create bundle manifest: %s", err)
+	}
+	defer manifestFile.Close()
+	err = json.NewEncoder(manifestFile).Encode(in.BundleManifest{
+		StoryID:    request.Version.StoryID,
+		Ref:        request.Version.Ref,
+		BundleRef:  bundleRef,
+		BaseBranch: baseBranch,
+		BaseRef:    baseRef,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Could not write bundle manifest: %s", err)
+	}
+	return request.Params.Bundle, nil
+}
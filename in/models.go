@@ -5,6 +5,26 @@ import "github.com/adamstegman/tracker-git-branch-resource"
 type InRequest struct {
 	Source  resource.Source  `json:"source"`
 	Version resource.Version `json:"version"`
+	Params  InParams         `json:"params"`
+}
+
+type InParams struct {
+	Integrate  string `json:"integrate"`
+	BaseBranch string `json:"base_branch"`
+	Bundle     string `json:"bundle"`
+
+	SparseCheckout []string `json:"sparse_checkout"`
+
+	Verify       string   `json:"verify"`
+	VerifyStates []string `json:"verify_states"`
+}
+
+type BundleManifest struct {
+	StoryID    string `json:"story_id"`
+	Ref        string `json:"ref"`
+	BundleRef  string `json:"bundle_ref"`
+	BaseBranch string `json:"base_branch"`
+	BaseRef    string `json:"base_ref"`
 }
 
 type InResponse struct {
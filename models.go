@@ -1,11 +1,69 @@
 package resource
 
+// Which of the new commits check emits as versions.
+const (
+	VersionModeEvery           = "every"
+	VersionModeLatestPerBranch = "latest_per_branch"
+	VersionModeLatest          = "latest"
+)
+
+// What max_versions counts versions across.
+const (
+	MaxVersionsScopeOverall = "overall"
+	MaxVersionsScopeStory   = "story"
+)
+
 type Source struct {
-	Token      string   `json:"token"`
-	Projects   []string `json:"projects"`
-	TrackerURL string   `json:"tracker_url"`
-	Repo       string   `json:"repo"`
-	PrivateKey string   `json:"private_key"`
+	Token      string      `json:"token"`
+	Projects   []ProjectID `json:"projects"`
+	TrackerURL string      `json:"tracker_url"`
+	Repo       string      `json:"repo"`
+	BaseBranch string      `json:"base_branch"`
+	PrivateKey string      `json:"private_key"`
+	Username   string      `json:"username"`
+	Password   string      `json:"password"`
+
+	PrivateKeyPassphrase string `json:"private_key_passphrase"`
+
+	KnownHosts                      string   `json:"known_hosts"`
+	HostKeyFingerprints             []string `json:"host_key_fingerprints"`
+	InsecureSkipHostKeyVerification bool     `json:"insecure_skip_host_key_verification"`
+
+	Proxy   string `json:"proxy"`
+	NoProxy string `json:"no_proxy"`
+	CACerts string `json:"ca_certs"`
+
+	Labels        []string `json:"labels"`
+	ExcludeLabels []string `json:"exclude_labels"`
+	StoryTypes    []string `json:"story_types"`
+	OwnedBy       []Person `json:"owned_by"`
+	RequestedBy   []Person `json:"requested_by"`
+	Iteration     string   `json:"iteration"`
+	Epic          string   `json:"epic"`
+	Search        string   `json:"search"`
+
+	Paths       []string `json:"paths"`
+	IgnorePaths []string `json:"ignore_paths"`
+
+	CommitMessage       string   `json:"commit_message"`
+	IgnoreCommitMessage string   `json:"ignore_commit_message"`
+	DisableCISkip       bool     `json:"disable_ci_skip"`
+	Authors             []string `json:"authors"`
+	IgnoreAuthors       []string `json:"ignore_authors"`
+	Committers          []string `json:"committers"`
+	IgnoreCommitters    []string `json:"ignore_committers"`
+
+	CommitVerificationKeys []string `json:"commit_verification_keys"`
+
+	MaxBranchAge string `json:"max_branch_age"`
+	VersionMode  string `json:"version_mode"`
+
+	MaxVersions      *int   `json:"max_versions"`
+	MaxVersionsScope string `json:"max_versions_scope"`
+
+	TrackerMaxRetries     *int   `json:"tracker_max_retries"`
+	TrackerRequestTimeout string `json:"tracker_request_timeout"`
+	TrackerTimeout        string `json:"tracker_timeout"`
 }
 
 type Version struct {
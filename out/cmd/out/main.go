@@ -3,16 +3,36 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"time"
 
+	"github.com/adamstegman/tracker-git-branch-resource"
 	"github.com/adamstegman/tracker-git-branch-resource/out"
 )
 
+var stderr io.Writer = resource.NewRedactingWriter(os.Stderr, resource.Source{})
+
 func main() {
+	resource.ExitOnSignal()
+	defer resource.RunCleanups()
+
 	if len(os.Args) < 2 {
 		sayf("usage: %s <sources directory>\n", os.Args[0])
-		os.Exit(1)
+		resource.Exit(1)
+	}
+
+	var request out.OutRequest
+	err := resource.DecodeRequest(os.Stdin, &request)
+	if err != nil {
+		sayf("Could not parse input: %s\n", err)
+		resource.Exit(1)
+	}
+	stderr = resource.NewRedactingWriter(os.Stderr, request.Source)
+	err = request.Source.Validate()
+	if err != nil {
+		sayf("%s\n", err)
+		resource.Exit(1)
 	}
 
 	json.NewEncoder(os.Stdout).Encode(out.OutResponse{
@@ -23,5 +43,5 @@ func main() {
 }
 
 func sayf(message string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, message, args...)
+	fmt.Fprintf(stderr, message, args...)
 }
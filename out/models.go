@@ -11,8 +11,10 @@ type OutRequest struct {
 	Params struct{}        `json:"params"`
 }
 
+// Version has always been emitted with the key Time, which existing pipelines
+// may depend on.
 type Version struct {
-	Time time.Time `json:time`
+	Time time.Time `json:"Time"`
 }
 
 type OutResponse struct {
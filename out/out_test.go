@@ -9,8 +9,10 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	. "github.com/onsi/gomega/gexec"
 
+	"github.com/adamstegman/tracker-git-branch-resource"
 	"github.com/adamstegman/tracker-git-branch-resource/out"
 )
 
@@ -39,7 +41,13 @@ var _ = Describe("Out", func() {
 		var response out.OutResponse
 
 		BeforeEach(func() {
-			request = out.OutRequest{}
+			request = out.OutRequest{
+				Source: resource.Source{
+					Token:    "trackerToken",
+					Projects: []resource.ProjectID{"123456"},
+					Repo:     "https://git.example.com/repo.git",
+				},
+			}
 			response = out.OutResponse{}
 		})
 
@@ -50,6 +58,29 @@ var _ = Describe("Out", func() {
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(response.Version.Time).Should(BeTemporally("~", time.Now(), time.Second))
 		})
+
+		It("keys the version's time as Time", func() {
+			session := runCommand(outCmd, request)
+
+			var version map[string]map[string]interface{}
+			err := json.Unmarshal(session.Out.Contents(), &version)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(version["version"]).Should(HaveKey("Time"))
+		})
+
+		It("rejects an invalid source", func() {
+			request.Source.Repo = ""
+
+			stdin, err := outCmd.StdinPipe()
+			Ω(err).ShouldNot(HaveOccurred())
+			session, err := Start(outCmd, GinkgoWriter, GinkgoWriter)
+			Ω(err).ShouldNot(HaveOccurred())
+			err = json.NewEncoder(stdin).Encode(request)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Eventually(session).Should(Exit(1))
+			Ω(session.Err).Should(gbytes.Say("repo is required"))
+		})
 	})
 })
 
@@ -0,0 +1,62 @@
+package resource
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var urlUserinfoPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/@\s]+@`)
+
+// Redactor masks the secrets of a source, and the userinfo of any URL, in
+// messages that are about to be shown to the user.
+type Redactor struct {
+	secrets []string
+}
+
+func NewRedactor(source Source) Redactor {
+	secrets := []string{}
+	for _, secret := range []string{source.Token, source.Password, source.PrivateKeyPassphrase, source.PrivateKey} {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	// Mask the key line by line too, in case it is printed reformatted
+	for _, line := range strings.Split(source.PrivateKey, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "-----") {
+			secrets = append(secrets, line)
+		}
+	}
+	return Redactor{secrets: secrets}
+}
+
+func (r Redactor) Redact(message string) string {
+	for _, secret := range r.secrets {
+		message = strings.Replace(message, secret, redacted, -1)
+	}
+	return urlUserinfoPattern.ReplaceAllString(message, "${1}"+redacted+"@")
+}
+
+type redactingWriter struct {
+	writer   io.Writer
+	redactor Redactor
+}
+
+// NewRedactingWriter redacts everything written to it before passing it on to
+// writer. Errors can contain whatever git or Tracker were given, so the
+// resource writes every message through one. Each write is redacted on its
+// own, so messages should be written whole, as fmt.Fprintf does.
+func NewRedactingWriter(writer io.Writer, source Source) io.Writer {
+	return redactingWriter{writer: writer, redactor: NewRedactor(source)}
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	_, err := io.WriteString(w.writer, w.redactor.Redact(string(p)))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -2,29 +2,210 @@ package resource
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
 
+// Merges and rebases are never pushed, but git still needs an identity to
+// record the resulting commits.
+const (
+	integrationUserName  = "Tracker Git Branch Resource"
+	integrationUserEmail = "tracker-git-branch-resource@localhost"
+)
+
+// The name of the directory, within $TMPDIR, that check keeps its clone in.
+const cacheDirName = "tracker-git-branch-resource-repo-cache"
+
+// The askpass script answers git's and ssh-add's prompts from these
+// variables, so that the credentials are never written to disk or into the
+// remote URL. A bad passphrase gets an empty answer so ssh-add gives up.
+const (
+	askpassUsernameEnv = "TRACKER_GIT_BRANCH_RESOURCE_USERNAME"
+	askpassPasswordEnv = "TRACKER_GIT_BRANCH_RESOURCE_PASSWORD"
+
+	askpassScript = `#!/bin/sh
+case "$1" in
+Username*) printf '%s\n' "$` + askpassUsernameEnv + `" ;;
+Bad\ passphrase*) printf '\n' ;;
+*) printf '%s\n' "$` + askpassPasswordEnv + `" ;;
+esac
+`
+)
+
 type Repository struct {
-	dir     string
-	keyFile string
-	source  string
+	ctx          context.Context
+	dir          string
+	authSocket   string
+	source       string
+	referenceDir string
+	askpassFile  string
+	username     string
+	password     string
+
+	knownHostsFile                  string
+	insecureSkipHostKeyVerification bool
+
+	proxy      string
+	noProxy    string
+	caInfoFile string
+
+	commitVerificationDir string
 }
 
-func NewRepository(source string, dir string, keyFile string) Repository {
+func NewRepository(source string, dir string, authSocket string) Repository {
 	return Repository{
-		dir:     dir,
-		keyFile: keyFile,
-		source:  source,
+		ctx:        context.Background(),
+		dir:        dir,
+		authSocket: authSocket,
+		source:     source,
 	}
 }
 
+// NewSourceRepository sets up the authentication and host key verification
+// described by the source. Anything it starts or writes to disk is registered
+// with AddCleanup, and every command it runs is killed when ctx is done.
+func NewSourceRepository(ctx context.Context, source Source, dir string) (Repository, error) {
+	var authSocket string
+	if source.PrivateKey != "" {
+		agent, err := StartSSHAgent(ctx, source.PrivateKey, source.PrivateKeyPassphrase)
+		if err != nil {
+			return Repository{}, err
+		}
+		AddCleanup(agent.Stop)
+		authSocket = agent.Socket()
+	}
+	repository := NewRepository(source.Repo, dir, authSocket).WithContext(ctx)
+
+	if source.Username != "" || source.Password != "" {
+		askpassFile, err := CreateAskpassFile()
+		if err != nil {
+			return Repository{}, err
+		}
+		AddCleanup(func() { os.Remove(askpassFile) })
+		repository = repository.WithCredentials(askpassFile, source.Username, source.Password)
+	}
+
+	var knownHostsFile string
+	if source.KnownHosts != "" || len(source.HostKeyFingerprints) > 0 {
+		var err error
+		knownHostsFile, err = CreateKnownHostsFile(ctx, source.KnownHosts, source.Repo, source.HostKeyFingerprints)
+		if err != nil {
+			return Repository{}, err
+		}
+		AddCleanup(func() { os.Remove(knownHostsFile) })
+	}
+	repository = repository.WithHostKeyVerification(knownHostsFile, source.InsecureSkipHostKeyVerification)
+
+	var caInfoFile string
+	if source.CACerts != "" {
+		var err error
+		caInfoFile, err = CreateCAInfoFile(source.CACerts)
+		if err != nil {
+			return Repository{}, err
+		}
+		AddCleanup(func() { os.Remove(caInfoFile) })
+	}
+	repository = repository.WithHTTPSettings(source.Proxy, source.NoProxy, caInfoFile)
+
+	if len(source.CommitVerificationKeys) > 0 {
+		commitVerificationDir, err := CreateCommitVerificationDir(ctx, source.CommitVerificationKeys)
+		if err != nil {
+			return Repository{}, err
+		}
+		AddCleanup(func() { os.RemoveAll(commitVerificationDir) })
+		repository = repository.WithCommitVerification(commitVerificationDir)
+	}
+	return repository, nil
+}
+
+// CacheDir is where check keeps its clone between runs.
+func CacheDir() string {
+	return filepath.Join(os.Getenv("TMPDIR"), cacheDirName)
+}
+
+// WithContext returns a copy of the repository whose commands are killed when
+// ctx is done.
+func (r Repository) WithContext(ctx context.Context) Repository {
+	r.ctx = ctx
+	return r
+}
+
+// WithReference returns a copy of the repository that borrows objects from the
+// clone in referenceDir when cloning, if it is a clone of the same source.
+func (r Repository) WithReference(referenceDir string) Repository {
+	r.referenceDir = referenceDir
+	return r
+}
+
+// WithCredentials returns a copy of the repository that answers git's HTTPS
+// username and password prompts through the script in askpassFile.
+func (r Repository) WithCredentials(askpassFile string, username string, password string) Repository {
+	r.askpassFile = askpassFile
+	r.username = username
+	r.password = password
+	return r
+}
+
+// WithHostKeyVerification returns a copy of the repository that verifies SSH
+// host keys against knownHostsFile, or skips verification entirely if insecure.
+func (r Repository) WithHostKeyVerification(knownHostsFile string, insecure bool) Repository {
+	r.knownHostsFile = knownHostsFile
+	r.insecureSkipHostKeyVerification = insecure
+	return r
+}
+
+// WithHTTPSettings returns a copy of the repository that reaches HTTP(S)
+// remotes through proxy, unless they are in noProxy, trusting the certificates
+// in caInfoFile.
+func (r Repository) WithHTTPSettings(proxy string, noProxy string, caInfoFile string) Repository {
+	r.proxy = proxy
+	r.noProxy = noProxy
+	r.caInfoFile = caInfoFile
+	return r
+}
+
+// WithCommitVerification returns a copy of the repository that checks commit
+// signatures against the keys in commitVerificationDir.
+func (r Repository) WithCommitVerification(commitVerificationDir string) Repository {
+	r.commitVerificationDir = commitVerificationDir
+	return r
+}
+
 func (r Repository) Clone() error {
+	return r.clone()
+}
+
+// PartialClone clones without any blobs and restricts the working tree to the
+// given cone patterns, so that only blobs under them are fetched on checkout.
+func (r Repository) PartialClone(patterns []string) error {
+	err := r.clone("--filter=blob:none", "--no-checkout")
+	if err != nil {
+		return err
+	}
+	err = r.runRepoCmd("git", append([]string{"sparse-checkout", "set", "--cone"}, patterns...)...)
+	if err != nil {
+		return fmt.Errorf("Could not set sparse-checkout patterns %v: %s", patterns, err)
+	}
+	return nil
+}
+
+func (r Repository) SparseCheckoutPatterns() ([]string, error) {
+	patternsOutput, err := r.runRepoCmdOutput("git", "sparse-checkout", "list")
+	if err != nil {
+		return []string{}, fmt.Errorf("Could not list sparse-checkout patterns: %s", err)
+	}
+	if patternsOutput == "" {
+		return []string{}, nil
+	}
+	return strings.Split(patternsOutput, "\n"), nil
+}
+
+func (r Repository) clone(options ...string) error {
 	_, err := os.Stat(r.dir)
 	if err == nil {
 		// repository is already cloned
@@ -34,19 +215,42 @@ func (r Repository) Clone() error {
 		return fmt.Errorf("Could not stat repository dir %s: %s", r.dir, err)
 	}
 
-	cmd := exec.Command("git", "clone", r.source, r.dir)
-	if r.keyFile != "" {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_SSH_COMMAND=/usr/bin/ssh -i %s", r.keyFile))
+	if r.hasReference() {
+		err = r.runClone(append([]string{"--reference", r.referenceDir, "--dissociate"}, options...)...)
+		if err == nil {
+			return nil
+		}
+		// the reference may be mid-fetch or corrupt, so fall back to a full clone
 	}
+	return r.runClone(options...)
+}
+
+func (r Repository) runClone(options ...string) error {
+	args := append(append([]string{"clone"}, options...), r.source, r.dir)
+	cmd := command(r.ctx, "git", args...)
+	cmd.Env = r.env()
 	var errBytes bytes.Buffer
 	cmd.Stderr = &errBytes
-	err = cmd.Run()
+	err := cmd.Run()
 	if err != nil {
-		return fmt.Errorf("Could not clone repository: git clone %s %s failed: %s\n[STDERR]\n%s", r.source, r.dir, err, errBytes.String())
+		return fmt.Errorf("Could not clone repository: git %s failed: %s\n[STDERR]\n%s", strings.Join(args, " "), err, errBytes.String())
 	}
 	return nil
 }
 
+func (r Repository) hasReference() bool {
+	if r.referenceDir == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(r.referenceDir, ".git"))
+	if err != nil {
+		return false
+	}
+	reference := NewRepository("", r.referenceDir, "")
+	url, err := reference.runRepoCmdOutput("git", "config", "--get", "remote.origin.url")
+	return err == nil && url == r.source
+}
+
 func (r Repository) Fetch() error {
 	err := r.runRepoCmd("git", "fetch", "origin")
 	if err != nil {
@@ -63,20 +267,121 @@ func (r Repository) CheckoutRef(ref string) error {
 	return nil
 }
 
+func (r Repository) MergeRef(baseBranch string, ref string) error {
+	base := "origin/" + baseBranch
+	err := r.runRepoCmd("git", "checkout", "--detach", base)
+	if err != nil {
+		return fmt.Errorf("Could not checkout %s: %s", base, err)
+	}
+	err = r.runRepoCmd("git", "-c", "user.name="+integrationUserName, "-c", "user.email="+integrationUserEmail, "merge", "--no-ff", "--no-edit", ref)
+	if err != nil {
+		conflicts, conflictsErr := r.conflictingPaths()
+		r.runRepoCmd("git", "merge", "--abort")
+		if conflictsErr == nil && len(conflicts) > 0 {
+			return fmt.Errorf("Could not merge %s into %s, conflicting paths:\n%s", ref, base, strings.Join(conflicts, "\n"))
+		}
+		return fmt.Errorf("Could not merge %s into %s: %s", ref, base, err)
+	}
+	return nil
+}
+
+func (r Repository) RebaseRef(baseBranch string, ref string) error {
+	base := "origin/" + baseBranch
+	err := r.runRepoCmd("git", "checkout", "--detach", ref)
+	if err != nil {
+		return fmt.Errorf("Could not checkout %s: %s", ref, err)
+	}
+	err = r.runRepoCmd("git", "-c", "user.name="+integrationUserName, "-c", "user.email="+integrationUserEmail, "rebase", base)
+	if err != nil {
+		conflicts, conflictsErr := r.conflictingPaths()
+		r.runRepoCmd("git", "rebase", "--abort")
+		if conflictsErr == nil && len(conflicts) > 0 {
+			return fmt.Errorf("Could not rebase %s onto %s, conflicting paths:\n%s", ref, base, strings.Join(conflicts, "\n"))
+		}
+		return fmt.Errorf("Could not rebase %s onto %s: %s", ref, base, err)
+	}
+	return nil
+}
+
+// CreateBundle writes a bundle that can be cloned on its own: it holds the
+// base branch, checked out by default, and the ref as bundleBranch. The refs
+// are assembled in a scratch repository so the checkout's own are untouched.
+func (r Repository) CreateBundle(file string, bundleBranch string, ref string, baseBranch string) error {
+	scratchDir, err := ioutil.TempDir("", "tracker-git-branch-resource-bundle")
+	if err != nil {
+		return fmt.Errorf("Could not create bundle repository: %s", err)
+	}
+	defer os.RemoveAll(scratchDir)
+	scratch := r
+	scratch.dir = scratchDir
+
+	err = scratch.runRepoCmd("git", "init", "--quiet", "--bare")
+	if err != nil {
+		return fmt.Errorf("Could not create bundle repository: %s", err)
+	}
+	base := "origin/" + baseBranch
+	err = r.runRepoCmd("git", "push", "--quiet", scratchDir,
+		"refs/remotes/"+base+":refs/heads/"+baseBranch,
+		ref+":refs/heads/"+bundleBranch,
+	)
+	if err != nil {
+		return fmt.Errorf("Could not copy %s and %s into the bundle: %s", base, ref, err)
+	}
+	err = scratch.runRepoCmd("git", "symbolic-ref", "HEAD", "refs/heads/"+baseBranch)
+	if err != nil {
+		return fmt.Errorf("Could not point the bundle's HEAD at %s: %s", baseBranch, err)
+	}
+	err = scratch.runRepoCmd("git", "bundle", "create", "--quiet", file, "HEAD", "refs/heads/"+baseBranch, "refs/heads/"+bundleBranch)
+	if err != nil {
+		return fmt.Errorf("Could not bundle %s with %s: %s", ref, base, err)
+	}
+	err = scratch.runRepoCmd("git", "bundle", "verify", "--quiet", file)
+	if err != nil {
+		return fmt.Errorf("Could not verify bundle %s: %s", file, err)
+	}
+	return nil
+}
+
+// Exclude keeps git from reporting the path, relative to the work tree, as
+// untracked, without changing any tracked .gitignore.
+func (r Repository) Exclude(path string) error {
+	excludeFile, err := r.runRepoCmdOutput("git", "rev-parse", "--git-path", "info/exclude")
+	if err != nil {
+		return fmt.Errorf("Could not find the exclude file: %s", err)
+	}
+	if !filepath.IsAbs(excludeFile) {
+		excludeFile = filepath.Join(r.dir, excludeFile)
+	}
+	err = os.MkdirAll(filepath.Dir(excludeFile), 0755)
+	if err != nil {
+		return fmt.Errorf("Could not create %s: %s", filepath.Dir(excludeFile), err)
+	}
+	f, err := os.OpenFile(excludeFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not open %s: %s", excludeFile, err)
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "/%s\n", filepath.ToSlash(path))
+	if err != nil {
+		return fmt.Errorf("Could not exclude %s in %s: %s", path, excludeFile, err)
+	}
+	return nil
+}
+
 func (r Repository) RemoteBranches() ([]string, error) {
 	branchesOutput, err := r.runRepoCmdOutput("git", "branch", "-r")
 	if err != nil {
 		return []string{}, fmt.Errorf("Could not list remote branches: %s", err)
 	}
-	branches := strings.Split(branchesOutput, "\n")
+	return trimBranches(branchesOutput), nil
+}
 
-	trimmedBranches := []string{}
-	for _, branch := range branches {
-		if !strings.Contains(branch, "origin/HEAD ->") {
-			trimmedBranches = append(trimmedBranches, strings.TrimSpace(branch))
-		}
+func (r Repository) RemoteBranchesContaining(ref string) ([]string, error) {
+	branchesOutput, err := r.runRepoCmdOutput("git", "branch", "-r", "--contains", ref)
+	if err != nil {
+		return []string{}, fmt.Errorf("Could not list remote branches containing %s: %s", ref, err)
 	}
-	return trimmedBranches, nil
+	return trimBranches(branchesOutput), nil
 }
 
 func (r Repository) RefAuthorName(ref string) (string, error) {
@@ -140,19 +445,28 @@ func (r Repository) LatestRef(branch string) (string, error) {
 	return strings.Trim(refOutput, "\""), nil
 }
 
-func (r Repository) RefsSinceTimestamp(branch string, timestamp int64) ([]string, error) {
-	refsOutput, err := r.runRepoCmdOutput("git", "log", fmt.Sprintf("--since=%d", timestamp), "--format=\"%H\"", branch)
+func (r Repository) TreeRef(ref string) (string, error) {
+	treeOutput, err := r.runRepoCmdOutput("git", "rev-parse", ref+"^{tree}")
 	if err != nil {
-		return []string{}, fmt.Errorf("Could not list refs since %d for %s: %s", timestamp, branch, err)
+		return "", fmt.Errorf("Could not show tree SHA for %s: %s", ref, err)
 	}
-	return strings.Split(refsOutput, "\n"), nil
+	return treeOutput, nil
 }
 
-func (r Repository) runRepoCmd(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	if r.keyFile != "" {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_SSH_COMMAND=/usr/bin/ssh -i %s", r.keyFile))
+func (r Repository) conflictingPaths() ([]string, error) {
+	pathsOutput, err := r.runRepoCmdOutput("git", "diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return []string{}, fmt.Errorf("Could not list conflicting paths: %s", err)
+	}
+	if pathsOutput == "" {
+		return []string{}, nil
 	}
+	return strings.Split(pathsOutput, "\n"), nil
+}
+
+func (r Repository) runRepoCmd(name string, args ...string) error {
+	cmd := command(r.ctx, name, args...)
+	cmd.Env = r.env()
 	cmd.Dir = r.dir
 	var errBytes bytes.Buffer
 	cmd.Stderr = &errBytes
@@ -164,10 +478,8 @@ func (r Repository) runRepoCmd(name string, args ...string) error {
 }
 
 func (r Repository) runRepoCmdOutput(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	if r.keyFile != "" {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_SSH_COMMAND=/usr/bin/ssh -i %s", r.keyFile))
-	}
+	cmd := command(r.ctx, name, args...)
+	cmd.Env = r.env()
 	cmd.Dir = r.dir
 	var outputBytes bytes.Buffer
 	cmd.Stdout = &outputBytes
@@ -180,15 +492,68 @@ func (r Repository) runRepoCmdOutput(name string, args ...string) (string, error
 	return strings.TrimSpace(outputBytes.String()), nil
 }
 
-func CreateKeyFile(privateKey string) (string, error) {
-	keyFile, err := ioutil.TempFile("", "tracker-git-branch-resource")
+func trimBranches(branchesOutput string) []string {
+	trimmedBranches := []string{}
+	if branchesOutput == "" {
+		return trimmedBranches
+	}
+	for _, branch := range strings.Split(branchesOutput, "\n") {
+		if !strings.Contains(branch, "origin/HEAD ->") {
+			trimmedBranches = append(trimmedBranches, strings.TrimSpace(branch))
+		}
+	}
+	return trimmedBranches
+}
+
+func (r Repository) env() []string {
+	env := os.Environ()
+	if r.authSocket != "" {
+		env = append(env, "SSH_AUTH_SOCK="+r.authSocket)
+	}
+	if sshCommand := r.sshCommand(); sshCommand != "" {
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	gitConfig := [][2]string{}
+	if r.proxy != "" {
+		gitConfig = append(gitConfig, [2]string{"http.proxy", r.proxy})
+	}
+	if r.caInfoFile != "" {
+		gitConfig = append(gitConfig, [2]string{"http.sslCAInfo", r.caInfoFile})
+	}
+	if r.commitVerificationDir != "" {
+		gitConfig = append(gitConfig, [2]string{"gpg.ssh.allowedSignersFile", filepath.Join(r.commitVerificationDir, allowedSignersName)})
+		env = append(env, "GNUPGHOME="+filepath.Join(r.commitVerificationDir, gnupgHomeName))
+	}
+	if len(gitConfig) > 0 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(gitConfig)))
+		for i, config := range gitConfig {
+			env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, config[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, config[1]))
+		}
+	}
+	if r.noProxy != "" {
+		env = append(env, "no_proxy="+r.noProxy, "NO_PROXY="+r.noProxy)
+	}
+	if r.askpassFile != "" {
+		env = append(env,
+			"GIT_ASKPASS="+r.askpassFile,
+			"GIT_TERMINAL_PROMPT=0",
+			askpassUsernameEnv+"="+r.username,
+			askpassPasswordEnv+"="+r.password,
+		)
+	}
+	return env
+}
+
+func CreateAskpassFile() (string, error) {
+	askpassFile, err := ioutil.TempFile("", "tracker-git-branch-resource-askpass")
 	if err != nil {
-		return "", fmt.Errorf("Could not create keyfile: %s", err)
+		return "", fmt.Errorf("Could not create askpass file: %s", err)
 	}
-	keyFile.Chmod(0600)
-	_, err = keyFile.WriteString(privateKey)
+	defer askpassFile.Close()
+	askpassFile.Chmod(0700)
+	_, err = askpassFile.WriteString(askpassScript)
 	if err != nil {
-		return "", fmt.Errorf("Could not write keyfile %s: %s", keyFile, err)
+		return "", fmt.Errorf("Could not write askpass file %s: %s", askpassFile.Name(), err)
 	}
-	return keyFile.Name(), nil
+	return askpassFile.Name(), nil
 }
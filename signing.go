@@ -0,0 +1,184 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	pgpPublicKeyHeader  = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	gnupgHomeName       = "gnupg"
+	allowedSignersName  = "allowed_signers"
+	signatureStatusGood = "G"
+)
+
+// Signature is how git judged a commit's signature against the trusted keys.
+type Signature struct {
+	// Status is git's %G? code, e.g. G for a good signature by a trusted key.
+	Status string
+	Signer string
+	Key    string
+}
+
+func (s Signature) Trusted() bool {
+	return s.Status == signatureStatusGood
+}
+
+func (s Signature) Problem() string {
+	switch s.Status {
+	case signatureStatusGood:
+		return ""
+	case "N", "":
+		return "it is not signed"
+	case "U":
+		return fmt.Sprintf("it is signed by untrusted key %s", s.Key)
+	case "B":
+		return "its signature is bad"
+	case "X", "Y":
+		return fmt.Sprintf("it is signed by expired key %s", s.Key)
+	case "R":
+		return fmt.Sprintf("it is signed by revoked key %s", s.Key)
+	default:
+		return fmt.Sprintf("its signature by key %s could not be checked", s.Key)
+	}
+}
+
+// RefSignature checks the signature on the ref against the repository's
+// commit verification keys.
+func (r Repository) RefSignature(ref string) (Signature, error) {
+	output, err := r.runRepoCmdOutput("git", "log", "-1", "--format=%G?%x00%GS%x00%GF", ref)
+	if err != nil {
+		return Signature{}, fmt.Errorf("Could not check the signature on %s: %s", ref, err)
+	}
+	fields := strings.SplitN(output, commitFieldSeparator, 3)
+	if len(fields) != 3 {
+		return Signature{}, fmt.Errorf("Could not parse the signature on %s: %q", ref, output)
+	}
+	return Signature{Status: fields[0], Signer: fields[1], Key: fields[2]}, nil
+}
+
+// CreateCommitVerificationDir writes a GnuPG home and an SSH allowed signers
+// file, which git checks commit signatures against, trusting only the given
+// keys.
+func CreateCommitVerificationDir(ctx context.Context, keys []string) (string, error) {
+	dir, err := ioutil.TempDir("", "tracker-git-branch-resource-signers")
+	if err != nil {
+		return "", fmt.Errorf("Could not create commit verification directory: %s", err)
+	}
+	err = os.Mkdir(filepath.Join(dir, gnupgHomeName), 0700)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("Could not create GnuPG home: %s", err)
+	}
+
+	allowedSigners := []string{}
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(key, pgpPublicKeyHeader) {
+			err = importTrustedPGPKey(ctx, filepath.Join(dir, gnupgHomeName), key)
+			if err != nil {
+				os.RemoveAll(dir)
+				return "", err
+			}
+			continue
+		}
+		for _, line := range strings.Split(key, "\n") {
+			if strings.TrimSpace(line) != "" {
+				allowedSigners = append(allowedSigners, allowedSigner(line))
+			}
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, allowedSignersName), []byte(strings.Join(allowedSigners, "\n")+"\n"), 0600)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("Could not write allowed signers file: %s", err)
+	}
+	return dir, nil
+}
+
+// allowedSigner turns an SSH public key into an allowed signers entry, with
+// the key's comment, if it has a usable one, as the principal git reports.
+func allowedSigner(key string) string {
+	fields := strings.Fields(key)
+	principal := "*"
+	if len(fields) == 3 && !strings.ContainsAny(fields[2], `,*?!"`) {
+		principal = fields[2]
+	}
+	if len(fields) > 2 {
+		fields = fields[:2]
+	}
+	return principal + " " + strings.Join(fields, " ")
+}
+
+// importTrustedPGPKey imports the key and trusts it ultimately, so that git
+// counts its signatures as good rather than of unknown validity.
+func importTrustedPGPKey(ctx context.Context, gnupgHome string, key string) error {
+	fingerprintsBefore, err := pgpFingerprints(ctx, gnupgHome)
+	if err != nil {
+		return err
+	}
+	_, err = runGPG(ctx, gnupgHome, key, "--import")
+	if err != nil {
+		return fmt.Errorf("Could not import commit verification key: %s", err)
+	}
+	fingerprints, err := pgpFingerprints(ctx, gnupgHome)
+	if err != nil {
+		return err
+	}
+
+	ownerTrust := ""
+	for fingerprint := range fingerprints {
+		if !fingerprintsBefore[fingerprint] {
+			ownerTrust += fingerprint + ":6:\n"
+		}
+	}
+	_, err = runGPG(ctx, gnupgHome, ownerTrust, "--import-ownertrust")
+	if err != nil {
+		return fmt.Errorf("Could not trust commit verification key: %s", err)
+	}
+	return nil
+}
+
+// pgpFingerprints lists the fingerprints of the primary keys in the keyring.
+func pgpFingerprints(ctx context.Context, gnupgHome string) (map[string]bool, error) {
+	output, err := runGPG(ctx, gnupgHome, "", "--with-colons", "--list-keys")
+	if err != nil {
+		return nil, fmt.Errorf("Could not list commit verification keys: %s", err)
+	}
+	fingerprints := map[string]bool{}
+	primary := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub":
+			primary = true
+		case fields[0] == "fpr" && primary && len(fields) > 9:
+			fingerprints[fields[9]] = true
+			primary = false
+		case fields[0] == "sub":
+			primary = false
+		}
+	}
+	return fingerprints, nil
+}
+
+func runGPG(ctx context.Context, gnupgHome string, input string, args ...string) (string, error) {
+	args = append([]string{"--batch", "--homedir", gnupgHome}, args...)
+	cmd := command(ctx, "gpg", args...)
+	cmd.Stdin = strings.NewReader(input)
+	var outputBytes bytes.Buffer
+	cmd.Stdout = &outputBytes
+	var errBytes bytes.Buffer
+	cmd.Stderr = &errBytes
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("gpg %v failed: %s\n[STDERR]\n%s", args, err, errBytes.String())
+	}
+	return outputBytes.String(), nil
+}
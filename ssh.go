@@ -0,0 +1,126 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var (
+	sshURLPattern = regexp.MustCompile(`^ssh://(?:[^@/]+@)?(\[[^\]]+\]|[^:/]+)(?::(\d+))?/`)
+	scpURLPattern = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):`)
+)
+
+func (r Repository) sshCommand() string {
+	if r.knownHostsFile == "" && !r.insecureSkipHostKeyVerification {
+		return ""
+	}
+	command := []string{"/usr/bin/ssh"}
+	if r.insecureSkipHostKeyVerification {
+		command = append(command, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+	} else if r.knownHostsFile != "" {
+		command = append(command, "-o", "StrictHostKeyChecking=yes", "-o", "UserKnownHostsFile="+r.knownHostsFile)
+	}
+	return strings.Join(command, " ")
+}
+
+// CreateKnownHostsFile writes the given known_hosts entries, followed by the
+// host keys of the repository's server that match the given fingerprints.
+func CreateKnownHostsFile(ctx context.Context, knownHosts string, repo string, fingerprints []string) (string, error) {
+	if len(fingerprints) > 0 {
+		scannedHosts, err := scanHostKeys(ctx, repo, fingerprints)
+		if err != nil {
+			return "", err
+		}
+		knownHosts = strings.TrimSpace(knownHosts) + "\n" + scannedHosts
+	}
+
+	knownHostsFile, err := ioutil.TempFile("", "tracker-git-branch-resource-known-hosts")
+	if err != nil {
+		return "", fmt.Errorf("Could not create known_hosts file: %s", err)
+	}
+	defer knownHostsFile.Close()
+	_, err = knownHostsFile.WriteString(strings.TrimSpace(knownHosts) + "\n")
+	if err != nil {
+		return "", fmt.Errorf("Could not write known_hosts file %s: %s", knownHostsFile.Name(), err)
+	}
+	return knownHostsFile.Name(), nil
+}
+
+func scanHostKeys(ctx context.Context, repo string, fingerprints []string) (string, error) {
+	host, port, ok := sshHost(repo)
+	if !ok {
+		return "", fmt.Errorf("Could not find an SSH host in %s to check host_key_fingerprints against", repo)
+	}
+	args := []string{host}
+	if port != "" {
+		args = []string{"-p", port, host}
+	}
+	cmd := command(ctx, "ssh-keyscan", args...)
+	var keysBytes bytes.Buffer
+	cmd.Stdout = &keysBytes
+	var errBytes bytes.Buffer
+	cmd.Stderr = &errBytes
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("Could not scan host keys: ssh-keyscan %v failed: %s\n[STDERR]\n%s", args, err, errBytes.String())
+	}
+
+	matchingKeys := []string{}
+	for _, key := range strings.Split(keysBytes.String(), "\n") {
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		fingerprint, err := hostKeyFingerprint(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		for _, trustedFingerprint := range fingerprints {
+			if !strings.HasPrefix(trustedFingerprint, "SHA256:") {
+				trustedFingerprint = "SHA256:" + trustedFingerprint
+			}
+			if fingerprint == trustedFingerprint {
+				matchingKeys = append(matchingKeys, key)
+				break
+			}
+		}
+	}
+	if len(matchingKeys) == 0 {
+		return "", fmt.Errorf("None of the host keys of %s match host_key_fingerprints %v", host, fingerprints)
+	}
+	return strings.Join(matchingKeys, "\n"), nil
+}
+
+func hostKeyFingerprint(ctx context.Context, key string) (string, error) {
+	cmd := command(ctx, "ssh-keygen", "-l", "-E", "sha256", "-f", "-")
+	cmd.Stdin = strings.NewReader(key)
+	var outputBytes bytes.Buffer
+	cmd.Stdout = &outputBytes
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("Could not fingerprint host key %s: %s", key, err)
+	}
+	fields := strings.Fields(outputBytes.String())
+	if len(fields) < 2 {
+		return "", fmt.Errorf("Could not parse fingerprint of host key %s: %s", key, outputBytes.String())
+	}
+	return fields[1], nil
+}
+
+// sshHost finds the host and optional port of an ssh:// or scp-like repository
+// URL.
+func sshHost(repo string) (string, string, bool) {
+	if matches := sshURLPattern.FindStringSubmatch(repo); matches != nil {
+		return strings.Trim(matches[1], "[]"), matches[2], true
+	}
+	if strings.Contains(repo, "://") {
+		return "", "", false
+	}
+	if matches := scpURLPattern.FindStringSubmatch(repo); matches != nil {
+		return matches[1], "", true
+	}
+	return "", "", false
+}
@@ -0,0 +1,100 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const sshAgentStartTimeout = 5 * time.Second
+
+// SSHAgent is an ssh-agent run by the resource on a socket only it can reach,
+// so that private keys never have to be written to disk.
+type SSHAgent struct {
+	dir    string
+	socket string
+	cmd    *exec.Cmd
+}
+
+func StartSSHAgent(ctx context.Context, privateKey string, passphrase string) (SSHAgent, error) {
+	dir, err := ioutil.TempDir("", "tracker-git-branch-resource-agent")
+	if err != nil {
+		return SSHAgent{}, fmt.Errorf("Could not create ssh-agent directory: %s", err)
+	}
+	agent := SSHAgent{
+		dir:    dir,
+		socket: filepath.Join(dir, "agent.sock"),
+	}
+	agent.cmd = exec.Command("ssh-agent", "-D", "-a", agent.socket)
+	err = agent.cmd.Start()
+	if err != nil {
+		os.RemoveAll(dir)
+		return SSHAgent{}, fmt.Errorf("Could not start ssh-agent: %s", err)
+	}
+
+	err = agent.waitForSocket()
+	if err != nil {
+		agent.Stop()
+		return SSHAgent{}, err
+	}
+	err = agent.addKey(ctx, privateKey, passphrase)
+	if err != nil {
+		agent.Stop()
+		return SSHAgent{}, err
+	}
+	return agent, nil
+}
+
+func (a SSHAgent) Socket() string {
+	return a.socket
+}
+
+func (a SSHAgent) Stop() {
+	if a.cmd != nil && a.cmd.Process != nil {
+		a.cmd.Process.Kill()
+		a.cmd.Wait()
+	}
+	os.RemoveAll(a.dir)
+}
+
+func (a SSHAgent) waitForSocket() error {
+	deadline := time.Now().Add(sshAgentStartTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(a.socket); err == nil {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("Could not start ssh-agent: %s did not appear within %s", a.socket, sshAgentStartTimeout)
+}
+
+func (a SSHAgent) addKey(ctx context.Context, privateKey string, passphrase string) error {
+	askpassFile := filepath.Join(a.dir, "askpass")
+	err := ioutil.WriteFile(askpassFile, []byte(askpassScript), 0700)
+	if err != nil {
+		return fmt.Errorf("Could not write askpass file %s: %s", askpassFile, err)
+	}
+
+	// The key is read from stdin, so the passphrase has to come from askpass
+	cmd := command(ctx, "ssh-add", "-")
+	cmd.Stdin = strings.NewReader(strings.TrimSpace(privateKey) + "\n")
+	cmd.Env = append(os.Environ(),
+		"SSH_AUTH_SOCK="+a.socket,
+		"SSH_ASKPASS="+askpassFile,
+		"SSH_ASKPASS_REQUIRE=force",
+		askpassPasswordEnv+"="+passphrase,
+	)
+	var errBytes bytes.Buffer
+	cmd.Stderr = &errBytes
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Could not load private key into ssh-agent: ssh-add failed: %s\n[STDERR]\n%s", err, errBytes.String())
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xoebus/go-tracker"
+)
+
+const (
+	defaultTrackerMaxRetries = 3
+	trackerMinBackoff        = time.Second
+	trackerMaxBackoff        = 30 * time.Second
+)
+
+// NewTrackerClient builds a Tracker client that retries failed reads, within
+// the timeouts given by the source, until ctx is done.
+func NewTrackerClient(ctx context.Context, source Source) (*tracker.Client, error) {
+	httpClient, err := NewHTTPClient(source)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := tracker.RetryPolicy{
+		MaxRetries: defaultTrackerMaxRetries,
+		MinBackoff: trackerMinBackoff,
+		MaxBackoff: trackerMaxBackoff,
+	}
+	if source.TrackerMaxRetries != nil {
+		policy.MaxRetries = *source.TrackerMaxRetries
+	}
+	if source.TrackerRequestTimeout != "" {
+		httpClient.Timeout, err = time.ParseDuration(source.TrackerRequestTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tracker_request_timeout %s: %s", source.TrackerRequestTimeout, err)
+		}
+	}
+	if source.TrackerTimeout != "" {
+		timeout, err := time.ParseDuration(source.TrackerTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tracker_timeout %s: %s", source.TrackerTimeout, err)
+		}
+		// The deadline stops requests and backoffs already under way, while the
+		// policy's copy saves waiting for a retry that couldn't finish in time
+		deadline := time.Now().Add(timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		AddCleanup(cancel)
+		policy.Deadline = deadline
+	}
+
+	trackerURL, err := TrackerURL(source)
+	if err != nil {
+		return nil, err
+	}
+	return tracker.NewClientWithURL(trackerURL, httpClient, tracker.Token(source.Token), tracker.Retry(policy), tracker.Context(ctx)), nil
+}
+
+// TrackerURL is the base URL of the source's Tracker. A missing scheme is
+// taken to be https, and trailing slashes or API paths are dropped.
+func TrackerURL(source Source) (string, error) {
+	trackerURL := strings.TrimSpace(source.TrackerURL)
+	if trackerURL == "" {
+		return tracker.DefaultURL, nil
+	}
+	if !strings.Contains(trackerURL, "://") {
+		trackerURL = "https://" + trackerURL
+	}
+	trackerURL = strings.TrimRight(trackerURL, "/")
+	trackerURL = strings.TrimSuffix(trackerURL, "/services/v5")
+
+	parsedURL, err := url.Parse(trackerURL)
+	if err != nil {
+		return "", fmt.Errorf("Invalid tracker_url %s: %s", source.TrackerURL, err)
+	}
+	if (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return "", fmt.Errorf("Invalid tracker_url %s: must be an http or https URL", source.TrackerURL)
+	}
+	return trackerURL, nil
+}
@@ -0,0 +1,195 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xoebus/go-tracker"
+)
+
+// ProjectID is a Tracker project ID, which may be given as a JSON number or
+// string.
+type ProjectID string
+
+func (id *ProjectID) UnmarshalJSON(data []byte) error {
+	s, err := numberOrString(data)
+	if err != nil {
+		return fmt.Errorf("project ID %s must be a number or a string", data)
+	}
+	*id = ProjectID(s)
+	return nil
+}
+
+func (id ProjectID) Int() (int, error) {
+	return strconv.Atoi(string(id))
+}
+
+// Person is a Tracker person, given as their ID or their username.
+type Person string
+
+func (p *Person) UnmarshalJSON(data []byte) error {
+	s, err := numberOrString(data)
+	if err != nil {
+		return fmt.Errorf("person %s must be an ID or a username", data)
+	}
+	*p = Person(s)
+	return nil
+}
+
+// ID is the person's ID, if they were given by ID rather than username.
+func (p Person) ID() (int, bool) {
+	id, err := strconv.Atoi(string(p))
+	return id, err == nil
+}
+
+func numberOrString(data []byte) (string, error) {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		err := json.Unmarshal(data, &s)
+		return s, err
+	}
+	var number json.Number
+	err := json.Unmarshal(data, &number)
+	return number.String(), err
+}
+
+// DecodeRequest decodes a request from Concourse, rejecting fields the
+// resource doesn't know so that a misspelt one isn't silently ignored.
+func DecodeRequest(r io.Reader, request interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(request)
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return fmt.Errorf("%s is not a known field, check its spelling against the README", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return err
+}
+
+// Validate reports every problem with the source at once, rather than just
+// the first one to be run into.
+func (s Source) Validate() error {
+	problems := []string{}
+
+	if s.Token == "" {
+		problems = append(problems, "token is required")
+	}
+	if len(s.Projects) == 0 {
+		problems = append(problems, "projects is required")
+	}
+	for _, project := range s.Projects {
+		if id, err := project.Int(); err != nil || id <= 0 {
+			problems = append(problems, fmt.Sprintf("project ID %q must be a positive integer", project))
+		}
+	}
+	if s.TrackerURL != "" {
+		if _, err := TrackerURL(s); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if s.Repo == "" {
+		problems = append(problems, "repo is required")
+	}
+	for _, label := range s.Labels {
+		if strings.TrimSpace(label) == "" {
+			problems = append(problems, "labels must not be blank")
+		}
+		for _, excludeLabel := range s.ExcludeLabels {
+			if strings.EqualFold(label, excludeLabel) {
+				problems = append(problems, fmt.Sprintf("label %q is in both labels and exclude_labels", label))
+			}
+		}
+	}
+	for _, excludeLabel := range s.ExcludeLabels {
+		if strings.TrimSpace(excludeLabel) == "" {
+			problems = append(problems, "exclude_labels must not be blank")
+		}
+	}
+
+	for _, storyType := range s.StoryTypes {
+		switch storyType {
+		case tracker.StoryTypeFeature, tracker.StoryTypeBug, tracker.StoryTypeChore, tracker.StoryTypeRelease:
+		default:
+			problems = append(problems, fmt.Sprintf("story type %q must be one of %s, %s, %s or %s", storyType, tracker.StoryTypeFeature, tracker.StoryTypeBug, tracker.StoryTypeChore, tracker.StoryTypeRelease))
+		}
+	}
+	switch s.Iteration {
+	case "", tracker.IterationScopeCurrent, tracker.IterationScopeBacklog, tracker.IterationScopeDone:
+	default:
+		problems = append(problems, fmt.Sprintf("iteration %q must be one of %s, %s or %s", s.Iteration, tracker.IterationScopeCurrent, tracker.IterationScopeBacklog, tracker.IterationScopeDone))
+	}
+	switch s.VersionMode {
+	case "", VersionModeEvery, VersionModeLatestPerBranch, VersionModeLatest:
+	default:
+		problems = append(problems, fmt.Sprintf("version_mode %q must be one of %s, %s or %s", s.VersionMode, VersionModeEvery, VersionModeLatestPerBranch, VersionModeLatest))
+	}
+	if s.MaxVersions != nil && *s.MaxVersions < 1 {
+		problems = append(problems, fmt.Sprintf("max_versions %d must be positive", *s.MaxVersions))
+	}
+	switch s.MaxVersionsScope {
+	case "", MaxVersionsScopeOverall, MaxVersionsScopeStory:
+	default:
+		problems = append(problems, fmt.Sprintf("max_versions_scope %q must be %s or %s", s.MaxVersionsScope, MaxVersionsScopeOverall, MaxVersionsScopeStory))
+	}
+	for _, person := range append(append([]Person{}, s.OwnedBy...), s.RequestedBy...) {
+		if strings.TrimSpace(strings.TrimPrefix(string(person), "@")) == "" {
+			problems = append(problems, "owned_by and requested_by must not be blank")
+		}
+	}
+
+	for _, pattern := range []struct{ name, value string }{
+		{"commit_message", s.CommitMessage},
+		{"ignore_commit_message", s.IgnoreCommitMessage},
+	} {
+		if _, err := regexp.Compile(pattern.value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s must be a regular expression: %s", pattern.name, pattern.value, err))
+		}
+	}
+
+	for _, key := range s.CommitVerificationKeys {
+		key = strings.TrimSpace(key)
+		if !strings.HasPrefix(key, pgpPublicKeyHeader) && len(strings.Fields(key)) < 2 {
+			problems = append(problems, "commit_verification_keys must be armored GPG public keys or SSH public keys")
+			break
+		}
+	}
+
+	if s.PrivateKeyPassphrase != "" && s.PrivateKey == "" {
+		problems = append(problems, "private_key_passphrase is set without a private_key")
+	}
+	if s.InsecureSkipHostKeyVerification && (s.KnownHosts != "" || len(s.HostKeyFingerprints) > 0) {
+		problems = append(problems, "insecure_skip_host_key_verification cannot be combined with known_hosts or host_key_fingerprints")
+	}
+
+	if s.Proxy != "" {
+		if proxyURL, err := url.Parse(s.Proxy); err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			problems = append(problems, fmt.Sprintf("proxy %s must be a URL such as http://proxy.example.com:3128", s.Proxy))
+		}
+	}
+
+	if s.TrackerMaxRetries != nil && *s.TrackerMaxRetries < 0 {
+		problems = append(problems, fmt.Sprintf("tracker_max_retries %d must not be negative", *s.TrackerMaxRetries))
+	}
+	for _, timeout := range []struct{ name, value string }{
+		{"tracker_request_timeout", s.TrackerRequestTimeout},
+		{"tracker_timeout", s.TrackerTimeout},
+		{"max_branch_age", s.MaxBranchAge},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(timeout.value); err != nil || duration <= 0 {
+			problems = append(problems, fmt.Sprintf("%s %s must be a positive duration such as 30s", timeout.name, timeout.value))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid source:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}